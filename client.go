@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/urfave/cli"
+)
+
+// tlsOptions builds the TLS options for the docker client from the command line.
+// Like the docker CLI, the certificates are taken from ca.pem, cert.pem and key.pem in
+// $DOCKER_CERT_PATH unless any of them is given. It returns nil when no TLS flags are
+// set and DOCKER_CERT_PATH isn't either, in which case the connection is plain.
+func tlsOptions(ctx *cli.Context) *tlsconfig.Options {
+	verify := ctx.Bool("tls-verify")
+	ca, cert, key := ctx.String("tls-ca"), ctx.String("tls-cert"), ctx.String("tls-key")
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" && ca == "" && cert == "" && key == "" {
+		ca = filepath.Join(certPath, "ca.pem")
+		cert = filepath.Join(certPath, "cert.pem")
+		key = filepath.Join(certPath, "key.pem")
+	}
+	if !verify && ca == "" && cert == "" && key == "" {
+		return nil
+	}
+	return &tlsconfig.Options{
+		CAFile:             ca,
+		CertFile:           cert,
+		KeyFile:            key,
+		InsecureSkipVerify: !verify,
+	}
+}
+
+// newDockerClient creates a docker client for the given socket, which may be a
+// unix://, tcp:// or npipe:// endpoint. When TLS options are given the connection
+// to a tcp:// endpoint is made over https.
+func newDockerClient(socket string, tlsOpts *tlsconfig.Options) (*dockerClient.Client, error) {
+	if tlsOpts == nil {
+		return dockerClient.NewClient(socket, api.DefaultVersion, nil, nil)
+	}
+
+	proto, addr, _, err := dockerClient.ParseHost(socket)
+	if err != nil {
+		return nil, err
+	}
+	if proto != "tcp" {
+		return nil, fmt.Errorf("TLS is only supported for tcp:// sockets, got: %s", socket)
+	}
+
+	tlsc, err := tlsconfig.Client(*tlsOpts)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsc}
+	if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+		return nil, err
+	}
+	return dockerClient.NewClient(socket, api.DefaultVersion, &http.Client{Transport: transport}, nil)
+}
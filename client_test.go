@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// tlsContext returns a context holding the TLS flags, as set on the command line.
+func tlsContext(verify bool, ca string) *cli.Context {
+	set := flag.NewFlagSet("dgc", flag.ContinueOnError)
+	set.Bool("tls-verify", verify, "")
+	set.String("tls-ca", ca, "")
+	set.String("tls-cert", "", "")
+	set.String("tls-key", "", "")
+	return cli.NewContext(nil, set, nil)
+}
+
+// writeCerts writes a self-signed certificate to dir as ca.pem, cert.pem and key.pem,
+// the way docker-machine lays them out.
+func writeCerts(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dgc"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	files := map[string][]byte{
+		"ca.pem":   cert,
+		"cert.pem": cert,
+		"key.pem":  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTLSOptionsFromDockerCertPath(t *testing.T) {
+	dir := t.TempDir()
+	writeCerts(t, dir)
+	// A docker-machine environment, where DOCKER_TLS_VERIFY sets --tls-verify
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:2376")
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	t.Setenv("DOCKER_CERT_PATH", dir)
+
+	opts := tlsOptions(tlsContext(true, ""))
+	if opts == nil {
+		t.Fatal("got no TLS options, want the certificates from DOCKER_CERT_PATH")
+	}
+	if opts.CAFile != filepath.Join(dir, "ca.pem") || opts.CertFile != filepath.Join(dir, "cert.pem") ||
+		opts.KeyFile != filepath.Join(dir, "key.pem") || opts.InsecureSkipVerify {
+		t.Errorf("got %+v, want the certificates in %s and verification", opts, dir)
+	}
+	client, err := newDockerClient("tcp://127.0.0.1:2376", opts)
+	if err != nil {
+		t.Fatalf("failed to create a client with the certificates: %s", err)
+	}
+	client.Close()
+
+	// Flags take precedence over DOCKER_CERT_PATH
+	if opts := tlsOptions(tlsContext(true, "/etc/dgc/ca.pem")); opts.CAFile != "/etc/dgc/ca.pem" || opts.CertFile != "" {
+		t.Errorf("got %+v, want only the CA given by --tls-ca", opts)
+	}
+
+	t.Setenv("DOCKER_CERT_PATH", "")
+	if opts := tlsOptions(tlsContext(false, "")); opts != nil {
+		t.Errorf("got %+v, want a plain connection", opts)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
+)
+
+// runConfigValidate checks the policy file given as an argument, or by --config, and
+// prints a summary of the policy and every rule in the order they are tried.
+func runConfigValidate(ctx *cli.Context) error {
+	file := ctx.Args().First()
+	if file == "" {
+		file = ctx.GlobalString("config")
+	}
+	if file == "" {
+		return cli.NewExitError("Error. No policy file given, pass one as an argument or with --config", 1)
+	}
+	policy, err := gc.ReadPolicy(file)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Invalid policy file: %s", err), 1)
+	}
+
+	fmt.Printf("%s is valid\n", file)
+	fmt.Printf("Grace period: %s\n", policy.Grace)
+	for _, kind := range []string{"container", "image", "volume", "network"} {
+		for _, rule := range policy.Rules[kind] {
+			if conditions := rule.Conditions(); conditions != "" {
+				fmt.Printf("%s, if %s\n", rule.String(), conditions)
+			} else {
+				fmt.Printf("%s, always\n", rule.String())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/robfig/cron"
+	"github.com/urfave/cli"
+)
+
+// errRunning is returned when a pass is requested while another one is in progress.
+var errRunning = errors.New("a garbage collection pass is already running")
+
+// hostReport is what a pass did to a single host, as reported by the HTTP API.
+type hostReport struct {
+	Socket     string           `json:"socket"`
+	Error      string           `json:"error,omitempty"`
+	Containers gc.TypeResult    `json:"containers"`
+	Images     gc.TypeResult    `json:"images"`
+	Volumes    gc.TypeResult    `json:"volumes"`
+	Networks   gc.TypeResult    `json:"networks"`
+	DiskBefore *gc.DiskSnapshot `json:"disk_before,omitempty"`
+	DiskAfter  *gc.DiskSnapshot `json:"disk_after,omitempty"`
+}
+
+// runReport is what a pass did across every docker host.
+type runReport struct {
+	// Trigger is what started the pass: "schedule" or "api".
+	Trigger  string       `json:"trigger"`
+	DryRun   bool         `json:"dry_run"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Error    string       `json:"error,omitempty"`
+	Hosts    []hostReport `json:"hosts"`
+}
+
+// daemonStatus is the current state of the service, as reported by the HTTP API.
+type daemonStatus struct {
+	Running  bool       `json:"running"`
+	Paused   bool       `json:"paused"`
+	Schedule string     `json:"schedule,omitempty"`
+	Next     *time.Time `json:"next,omitempty"`
+	LastRun  *runReport `json:"last_run,omitempty"`
+}
+
+// daemon runs garbage collection passes on schedule or on request, one at a time, and
+// remembers how the last one went.
+type daemon struct {
+	ctx      *cli.Context
+	usage    *gc.LastUsed
+	state    *gc.FirstSeen
+	schedule cron.Schedule
+	metrics  *metrics
+	// dryRun is set by --dry-run, which turns every pass into a dry run.
+	dryRun bool
+
+	lock    sync.Mutex
+	running bool
+	paused  bool
+	last    *runReport
+	passes  sync.WaitGroup
+}
+
+// begin claims the right to run a pass. It fails if one is already running.
+func (d *daemon) begin() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.running {
+		return errRunning
+	}
+	d.running = true
+	d.passes.Add(1)
+	return nil
+}
+
+// pass runs a garbage collection pass that was claimed with begin, as a dry run if
+// plan is set or the daemon only makes dry runs. Plans are not remembered as the last
+// run, and only passes that delete are counted in the metrics.
+func (d *daemon) pass(trigger string, plan bool) *runReport {
+	defer d.passes.Done()
+	dryRun := plan || d.dryRun
+	report := &runReport{Trigger: trigger, DryRun: dryRun, Started: time.Now()}
+	results, err := collectAll(d.ctx, d.usage, d.state, dryRun)
+	report.Finished = time.Now()
+	if !dryRun {
+		d.metrics.observe(results, err, report.Started, report.Finished)
+		if err := d.metrics.writeFile(d.ctx.String("metrics-file")); err != nil {
+			log.Printf("Error. Failed to write metrics file: %s\n", err)
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+		log.Printf("Error. Garbage collection failed: %s\n", err)
+	}
+	for _, result := range results {
+		host := hostReport{
+			Socket:     result.Socket,
+			Containers: result.Containers,
+			Images:     result.Images,
+			Volumes:    result.Volumes,
+			Networks:   result.Networks,
+			DiskBefore: result.Before,
+			DiskAfter:  result.After,
+		}
+		if result.Err != nil {
+			host.Error = result.Err.Error()
+			log.Printf("[%s] failed: %s\n", result.Socket, result.Err)
+		}
+		report.Hosts = append(report.Hosts, host)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.running = false
+	if !plan {
+		d.last = report
+	}
+	return report
+}
+
+// scheduled runs a pass on activation of the schedule, unless collection is paused.
+func (d *daemon) scheduled() {
+	d.lock.Lock()
+	paused := d.paused
+	d.lock.Unlock()
+	if paused {
+		log.Println("Skipping garbage collection, scheduled collection is paused.")
+		return
+	}
+	if err := d.begin(); err != nil {
+		log.Printf("Skipping garbage collection: %s\n", err)
+		return
+	}
+	d.pass("schedule", false)
+}
+
+// status returns the current state of the service.
+func (d *daemon) status() daemonStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	status := daemonStatus{
+		Running: d.running,
+		Paused:  d.paused,
+		LastRun: d.last,
+	}
+	if d.schedule != nil {
+		status.Schedule = d.ctx.String("schedule")
+		if !d.paused {
+			next := d.schedule.Next(time.Now())
+			status.Next = &next
+		}
+	}
+	return status
+}
+
+// setPaused pauses or resumes scheduled collection. Passes requested through the API
+// still run while paused.
+func (d *daemon) setPaused(paused bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.paused = paused
+}
+
+// writeJSON writes value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Error. Failed to write response: %s\n", err)
+	}
+}
+
+// writeError writes an error as the JSON body of the response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// handle routes requests for path to handler, rejecting any other method.
+func handle(mux *http.ServeMux, method, path string, handler http.HandlerFunc) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s only accepts %s", path, method))
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// handler returns the HTTP API of the service.
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	handle(mux, http.MethodGet, "/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.status())
+	})
+
+	handle(mux, http.MethodGet, "/report", func(w http.ResponseWriter, r *http.Request) {
+		if last := d.status().LastRun; last != nil {
+			writeJSON(w, http.StatusOK, last)
+			return
+		}
+		writeError(w, http.StatusNotFound, errors.New("no garbage collection pass has run yet"))
+	})
+
+	// Runs a pass in the background, the result shows up in /report once it is done
+	handle(mux, http.MethodPost, "/run", func(w http.ResponseWriter, r *http.Request) {
+		if err := d.begin(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		go d.pass("api", false)
+		writeJSON(w, http.StatusAccepted, d.status())
+	})
+
+	// Plans a pass and returns what it would delete, without deleting anything
+	handle(mux, http.MethodGet, "/plan", func(w http.ResponseWriter, r *http.Request) {
+		if err := d.begin(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, d.pass("api", true))
+	})
+
+	handle(mux, http.MethodGet, "/metrics", d.metrics.ServeHTTP)
+
+	handle(mux, http.MethodPost, "/pause", func(w http.ResponseWriter, r *http.Request) {
+		d.setPaused(true)
+		log.Println("Paused scheduled garbage collection.")
+		writeJSON(w, http.StatusOK, d.status())
+	})
+
+	handle(mux, http.MethodPost, "/resume", func(w http.ResponseWriter, r *http.Request) {
+		d.setPaused(false)
+		log.Println("Resumed scheduled garbage collection.")
+		writeJSON(w, http.StatusOK, d.status())
+	})
+
+	return mux
+}
+
+// runServe runs dgc as a long-lived service with a local HTTP API, collecting on the
+// --schedule if one is given, until the process receives SIGINT or SIGTERM.
+func runServe(ctx *cli.Context) error {
+	listen := ctx.String("listen")
+	// Collection is configured by the global flags
+	ctx = ctx.Parent()
+
+	usage, state, err := openTracking(ctx, false)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer state.Close()
+
+	d := &daemon{ctx: ctx, usage: usage, state: state, metrics: newMetrics(), dryRun: ctx.Bool("dry-run")}
+	if ctx.String("schedule") != "" {
+		if d.schedule, err = parseSchedule(ctx.String("schedule")); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Error. Invalid schedule: %s", err), 1)
+		}
+	}
+
+	// Watch for images being used between passes
+	if usage != nil {
+		stop, err := watchUsage(ctx, usage)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer stop()
+	}
+
+	server := &http.Server{Addr: listen, Handler: d.handler()}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving the HTTP API on %s\n", listen)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	if d.schedule != nil {
+		done := make(chan struct{})
+		go func() {
+			runScheduled(d.schedule, d.scheduled)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case err := <-serveErr:
+			return cli.NewExitError(fmt.Sprintf("Error. Failed to serve the HTTP API: %s", err), 1)
+		}
+	} else {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s, waiting for the current pass to finish...\n", sig)
+		case err := <-serveErr:
+			return cli.NewExitError(fmt.Sprintf("Error. Failed to serve the HTTP API: %s", err), 1)
+		}
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		log.Printf("Error. Failed to shut down the HTTP API: %s\n", err)
+	}
+	d.passes.Wait()
+	log.Println("Stopped serving.")
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/hatchery/dgc/gc/gctest"
+	"github.com/urfave/cli"
+)
+
+// daemonContext returns a context holding the global flags a pass needs, collecting
+// from socket.
+func daemonContext(socket string, dryRun bool) *cli.Context {
+	set := flag.NewFlagSet("dgc", flag.ContinueOnError)
+	set.String("socket", socket, "")
+	set.String("states", gc.DefaultStates, "")
+	set.Bool("dry-run", dryRun, "")
+	return cli.NewContext(nil, set, nil)
+}
+
+// exitedServer returns a fake docker daemon with a single container that exited an
+// hour ago.
+func exitedServer() *gctest.Server {
+	server := gctest.NewServer()
+	server.AddContainer(gctest.Container{ID: "job", State: "exited",
+		Created: time.Now().Add(-2 * time.Hour), Finished: time.Now().Add(-time.Hour)})
+	return server
+}
+
+// deletes returns the DELETE requests the fake daemon has served.
+func deletes(server *gctest.Server) []string {
+	var requests []string
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "DELETE ") {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func TestDaemonDryRun(t *testing.T) {
+	server := exitedServer()
+	defer server.Close()
+
+	// Every pass of a --dry-run daemon is a dry run, whatever started it
+	lastUsed := filepath.Join(t.TempDir(), gc.LastUsedFile)
+	usage, err := gc.LoadLastUsed(lastUsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := daemonContext(server.URL, true)
+	d := &daemon{ctx: ctx, usage: usage, metrics: newMetrics(), dryRun: ctx.Bool("dry-run")}
+	if err := d.begin(); err != nil {
+		t.Fatal(err)
+	}
+	report := d.pass("schedule", false)
+	if !report.DryRun || len(report.Hosts) != 1 || report.Hosts[0].Error != "" {
+		t.Fatalf("got %+v, want a dry run of the host", report)
+	}
+	if planned := report.Hosts[0].Containers.Planned; len(planned) != 1 || planned[0].ID != "job" {
+		t.Errorf("got planned %+v, want job", planned)
+	}
+	if requests := deletes(server); len(requests) != 0 {
+		t.Errorf("a dry run made requests %v", requests)
+	}
+	if _, err := os.Stat(lastUsed); !os.IsNotExist(err) {
+		t.Errorf("a dry run saved last used times: %v", err)
+	}
+	if last := d.status().LastRun; last != report {
+		t.Errorf("got last run %+v, want the scheduled dry run", last)
+	}
+}
+
+func TestDaemonPlanLeavesState(t *testing.T) {
+	server := exitedServer()
+	defer server.Close()
+	state, err := gc.OpenState(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	d := &daemon{ctx: daemonContext(server.URL, false), state: state, metrics: newMetrics()}
+	w := httptest.NewRecorder()
+	d.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/plan", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if requests := deletes(server); len(requests) != 0 {
+		t.Errorf("a plan made requests %v", requests)
+	}
+	// What the plan saw must not start a grace period
+	if len(state.Hosts) != 0 {
+		t.Errorf("got first seen times %v, want none", state.Hosts)
+	}
+	if last := d.status().LastRun; last != nil {
+		t.Errorf("got last run %+v, want the plan forgotten", last)
+	}
+}
@@ -1,178 +1,251 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"github.com/urfave/cli"
-	dockerTypes "github.com/docker/docker/api/types"
-	dockerClient "github.com/docker/docker/client"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
-	"context"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
 )
 
-func readExcludes(fileName string) []string {
-	var excludeNames []string
-	file, err := os.Open(fileName)
+// collectAll performs a single garbage collection pass across every docker host, or
+// only plans one if dryRun is set. usage holds when images were last used and state
+// when resources were first seen unused, either of which is nil if it isn't tracked.
+func collectAll(ctx *cli.Context, usage *gc.LastUsed, state *gc.FirstSeen, dryRun bool) ([]gc.Result, error) {
+	var dgcSync sync.WaitGroup
+
+	sockets, err := dockerSockets(ctx)
 	if err != nil {
-		log.Fatal("Error opening input file:", err)
-	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		excludeNames = append(excludeNames, scanner.Text())
+		return nil, err
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Error reading exclude file:", scanner.Err())
-	}
-	return excludeNames
-}
-
-func collectAPIImages(images []dockerTypes.ImageSummary, client *dockerClient.Client, ctx *cli.Context, excludes []string) {
-	var imageSync sync.WaitGroup
-	grace := ctx.Duration("grace")
-	quiet := ctx.Bool("quiet")
-	options := dockerTypes.ImageRemoveOptions{
-		Force:         ctx.Bool("force"),
-		PruneChildren: ctx.Bool("no-prune"),
+	policy, err := loadPolicy(ctx)
+	if err != nil {
+		return nil, err
 	}
+	policy.DryRun = dryRun
+	// Deletions are printed as they happen, the plan of a dry run once it is complete
+	printing := !dryRun && !ctx.Bool("quiet") && ctx.String("output") == outputText
 
-	for _, image := range images {
-		imageSync.Add(1)
-		go func(image dockerTypes.ImageSummary) {
-			defer imageSync.Done()
-
-			// Check if the image id or tag is on excludes list
-			for _, excludeName := range excludes {
-				if image.ID == excludeName {
-					return
-				}
-				for _, tag := range image.RepoTags {
-					if tag == excludeName {
-						return
-					}
+	pool := gc.NewWorkerPool(ctx.Int("concurrency"))
+	results := make([]gc.Result, len(sockets))
+	for i, socket := range sockets {
+		dgcSync.Add(1)
+		go func(i int, socket string) {
+			defer dgcSync.Done()
+			client, err := newDockerClient(socket, tlsOptions(ctx))
+			if err != nil {
+				results[i] = gc.Result{
+					Socket: socket,
+					Err:    fmt.Errorf("Error. Failed to create a docker client to: %s: %s", socket, err),
 				}
-			}
-
-			// End if the image is still in the grace period
-			log.Printf("Inspecting image: %s\n", image.ID)
-
-			now := time.Now()
-			if now.Sub(time.Unix(image.Created, 0)) < grace {
 				return
 			}
+			defer client.Close()
+			collector := newCollector(socket, client, policy)
+			collector.Usage = usage
+			collector.State = state
+			collector.Workers = pool.ForHost(ctx.Int("host-concurrency"), ctx.Float64("rate"))
+			defer collector.Workers.Stop()
+			if printing {
+				collector.OnRecord = printRecords(hostPrefix(socket, len(sockets)))
+			}
+			results[i] = collector.Collect()
+		}(i, socket)
+	}
+	dgcSync.Wait()
+	// A dry run pretends resources are gone, so what it saw must not be remembered
+	if dryRun {
+		state.Reset()
+		return results, nil
+	}
+	if err := usage.Save(); err != nil {
+		log.Printf("Error. Failed to save last used times: %s\n", err)
+	}
+	if err := state.Save(); err != nil {
+		log.Printf("Error. Failed to save state: %s\n", err)
+	}
+	return results, nil
+}
 
-			// Delete image
-			log.Printf("Deleting image: %s\n", image.ID)
+// runPass performs a single garbage collection pass across every docker host and
+// reports how it went. usage and state are passed on to collectAll, and the pass is
+// recorded in m unless it is a dry run.
+func runPass(ctx *cli.Context, usage *gc.LastUsed, state *gc.FirstSeen, m *metrics) error {
+	started := time.Now()
+	results, err := collectAll(ctx, usage, state, ctx.Bool("dry-run"))
+	if !ctx.Bool("dry-run") {
+		m.observe(results, err, started, time.Now())
+		if err := m.writeFile(ctx.String("metrics-file")); err != nil {
+			log.Printf("Error. Failed to write metrics file: %s\n", err)
+		}
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 
-			if _, err := client.ImageRemove(context.Background(), image.ID, options); err == nil {
-				log.Printf("Deleted image: %s\n", image.ID)
-				if !quiet {
-					fmt.Printf("Deleted image: %s\n", image.ID)
-				}
-			} else {
-				log.Printf("Error. Failed to delete image: %s\n", image.ID)
-				return
-			}
-		}(image)
+	// Machine readable output goes to stdout, everything else is logged to stderr
+	text := ctx.String("output") == outputText
+	if !text {
+		if err := writeOutput(os.Stdout, ctx.String("output"), results, ctx.Bool("dry-run")); err != nil {
+			log.Printf("Error. Failed to write output: %s\n", err)
+		}
 	}
 
-	imageSync.Wait()
+	failed := 0
+	for _, result := range results {
+		prefix := hostPrefix(result.Socket, len(results))
+		if text && ctx.Bool("dry-run") && result.Err == nil {
+			printPlan(prefix, result.Planned())
+		} else if text && !ctx.Bool("dry-run") && !ctx.Bool("quiet") {
+			printSummary(prefix, result)
+		}
+		if result.Err != nil {
+			log.Printf("[%s] failed: %s\n", result.Socket, result.Err)
+		} else {
+			log.Printf("[%s] deleted %d containers (%d failed), %d images (%d failed), %d volumes (%d failed), %d networks (%d failed)\n",
+				result.Socket,
+				result.Containers.Deleted, result.Containers.Failed,
+				result.Images.Deleted, result.Images.Failed,
+				result.Volumes.Deleted, result.Volumes.Failed,
+				result.Networks.Deleted, result.Networks.Failed)
+		}
+		if result.Failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("Error. Garbage collection failed on %d of %d hosts", failed, len(results)), 1)
+	}
+	return nil
 }
 
-func collectAPIContainers(containers []dockerTypes.Container, client *dockerClient.Client, ctx *cli.Context, excludes []string) {
-	var containerSync sync.WaitGroup
-	grace := ctx.Duration("grace")
-	quiet := ctx.Bool("quiet")
+// openTracking opens the state directory and reads the last used times, if the flags
+// ask for them to be tracked. The state must be closed once collection is over. If
+// readOnly is set, the state is read without waiting for the lock a running dgc holds,
+// and can't be saved.
+func openTracking(ctx *cli.Context, readOnly bool) (*gc.LastUsed, *gc.FirstSeen, error) {
+	var usage *gc.LastUsed
+	var state *gc.FirstSeen
+	lastUsedPath := ctx.String("last-used-file")
+	if ctx.String("state-dir") != "" {
+		open := gc.OpenState
+		if readOnly {
+			open = gc.ReadState
+		}
+		var err error
+		if state, err = open(ctx.String("state-dir")); err != nil {
+			return nil, nil, fmt.Errorf("Error. Failed to open state directory: %s", err)
+		}
+		if lastUsedPath == "" {
+			lastUsedPath = filepath.Join(ctx.String("state-dir"), gc.LastUsedFile)
+		}
+	}
+	if lastUsedPath != "" {
+		var err error
+		if usage, err = gc.LoadLastUsed(lastUsedPath); err != nil {
+			state.Close()
+			return nil, nil, fmt.Errorf("Error. Failed to read last used times: %s", err)
+		}
+	}
+	return usage, state, nil
+}
 
-	for _, container := range containers {
-		containerSync.Add(1)
-		go func(container dockerTypes.Container) {
-			defer containerSync.Done()
+func runDgc(ctx *cli.Context) error {
+	if err := checkOutput(ctx.String("output")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 
-			// Check if the container id or tag is on excludes list
-			for _, excludeName := range excludes {
-				if container.ID == excludeName {
-					return
-				}
-				if container.Image == excludeName {
-					return
-				}
-				for _, name := range container.Names {
-					if name == excludeName {
-						return
-					}
-				}
-			}
+	usage, state, err := openTracking(ctx, false)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer state.Close()
 
-			// End if the container is still in the grace period
-			now := time.Now()
-			if now.Sub(time.Unix(container.Created, 0)) < grace {
-				return
-			}
+	var m *metrics
+	if ctx.String("metrics-file") != "" || ctx.String("metrics-listen") != "" {
+		m = newMetrics()
+	}
 
-			// Delete container
-			options := dockerTypes.ContainerRemoveOptions{
-				RemoveVolumes: ctx.Bool("remove-volumes"),
-				Force:         ctx.Bool("force"),
-			}
+	if ctx.String("schedule") == "" {
+		return runPass(ctx, usage, state, m)
+	}
+
+	schedule, err := parseSchedule(ctx.String("schedule"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Invalid schedule: %s", err), 1)
+	}
 
-			log.Printf("Deleting container: %s\n", container.ID)
+	// Watch for images being used between passes
+	if usage != nil {
+		stop, err := watchUsage(ctx, usage)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer stop()
+	}
 
-			if err := client.ContainerRemove(context.Background(), container.ID, options); err == nil {
-				log.Printf("Deleted container: %s\n", container.ID)
-				if !quiet {
-					fmt.Printf("Deleted container: %s\n", container.ID)
-				}
-			} else {
-				log.Printf("Error. Failed to delete container: %s\n", container.ID)
-				return
+	// Expose metrics for as long as passes keep running
+	if ctx.String("metrics-listen") != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		server := &http.Server{Addr: ctx.String("metrics-listen"), Handler: mux}
+		go func() {
+			log.Printf("Serving metrics on %s\n", server.Addr)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("Error. Failed to serve metrics: %s\n", err)
 			}
-		}(container)
+		}()
+		defer server.Close()
 	}
 
-	containerSync.Wait()
+	runScheduled(schedule, func() {
+		if err := runPass(ctx, usage, state, m); err != nil {
+			log.Println(err)
+		}
+	})
+	return nil
 }
 
-func runDgc(ctx *cli.Context) {
-	var dgcSync sync.WaitGroup
-	var excludes []string
-
-	// TODO: change this to use socket
-	client, err := dockerClient.NewEnvClient()
+// watchUsage starts watching every docker host for images being used. The returned
+// function stops the watchers and saves what they recorded.
+func watchUsage(ctx *cli.Context, usage *gc.LastUsed) (func(), error) {
+	var watchSync sync.WaitGroup
+	sockets, err := dockerSockets(ctx)
 	if err != nil {
-		log.Fatalf("Error. Failed to create a docker client to: %s", ctx.String("socket"))
+		return nil, err
 	}
 
-	log.Println("Getting a List of images...")
-	images, err := client.ImageList(context.Background(), dockerTypes.ImageListOptions{All: true})
-	if err != nil {
-		log.Fatal("Error. Failed to retrieve images from the docker host.")
+	watchCtx, cancel := context.WithCancel(context.Background())
+	for _, socket := range sockets {
+		client, err := newDockerClient(socket, tlsOptions(ctx))
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("Error. Failed to create a docker client to: %s: %s", socket, err)
+		}
+		collector := newCollector(socket, client, nil)
+		collector.Usage = usage
+		watchSync.Add(1)
+		go func() {
+			defer watchSync.Done()
+			defer client.Close()
+			collector.WatchUsage(watchCtx)
+		}()
 	}
 
-	log.Println("Getting a list of containers...")
-	containers, err := client.ContainerList(context.Background(), dockerTypes.ContainerListOptions{All: true})
-	if err != nil {
-		log.Fatal("Error. Failed to retrieve containers from the docker host.")
-	}
-	if ctx.String("exclude") != "" {
-		excludes = readExcludes(ctx.String("exclude"))
-	}
-
-	dgcSync.Add(2)
-	log.Println("Performing garbage collection...")
-	go func() {
-		defer dgcSync.Done()
-		collectAPIContainers(containers, client, ctx, excludes)
-	}()
-	go func() {
-		defer dgcSync.Done()
-		collectAPIImages(images, client, ctx, excludes)
-	}()
-	dgcSync.Wait()
-	log.Println("Finished garbage collection!")
+	return func() {
+		cancel()
+		watchSync.Wait()
+		if err := usage.Save(); err != nil {
+			log.Printf("Error. Failed to save last used times: %s\n", err)
+		}
+	}, nil
 }
 
 func main() {
@@ -190,18 +263,138 @@ func main() {
 			Usage:  "the grace period for a container. Accepted compostable time units: [h, m, s, ms, ns us]",
 			EnvVar: "GRACE_PERIOD_SECONDS,GRACE_PERIOD",
 		},
+		cli.StringFlag{
+			Name:   "schedule",
+			Value:  "",
+			Usage:  "keep running and collect on a schedule, as a cron expression (\"0 3 * * *\"), descriptor (\"@every 30m\") or interval (\"30m\")",
+			EnvVar: "GC_SCHEDULE",
+		},
 		cli.StringFlag{
 			Name:   "socket, s",
 			Value:  "unix:///var/run/docker.sock",
-			Usage:  "the docker remote socket",
-			EnvVar: "DOCKER_SOCKET",
+			Usage:  "the docker remote socket, as a unix://, tcp:// or npipe:// endpoint. Separate multiple sockets with commas",
+			EnvVar: "DOCKER_SOCKET,DOCKER_HOST",
+		},
+		cli.IntFlag{
+			Name:   "concurrency, c",
+			Value:  8,
+			Usage:  "the maximum number of deletions to run at once across all hosts",
+			EnvVar: "GC_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   "host-concurrency",
+			Value:  4,
+			Usage:  "the maximum number of deletions to run at once on a single host, 0 for no limit beyond --concurrency",
+			EnvVar: "GC_HOST_CONCURRENCY",
+		},
+		cli.Float64Flag{
+			Name:   "rate",
+			Value:  0,
+			Usage:  "the maximum number of deletions per second on a single host, 0 for no limit",
+			EnvVar: "GC_RATE",
+		},
+		cli.StringFlag{
+			Name:   "hosts-file",
+			Value:  "",
+			Usage:  "a file listing docker remote sockets to collect concurrently, one per line",
+			EnvVar: "DOCKER_HOSTS_FILE",
+		},
+		cli.StringFlag{
+			Name:   "tls-ca",
+			Value:  "",
+			Usage:  "trust certs signed only by this CA when connecting over tcp://",
+			EnvVar: "DOCKER_TLS_CA",
+		},
+		cli.StringFlag{
+			Name:   "tls-cert",
+			Value:  "",
+			Usage:  "the TLS client certificate file",
+			EnvVar: "DOCKER_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "tls-key",
+			Value:  "",
+			Usage:  "the TLS client key file",
+			EnvVar: "DOCKER_TLS_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "tls-verify",
+			Usage:  "use TLS and verify the remote daemon's certificate",
+			EnvVar: "DOCKER_TLS_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "YAML policy file with per-type rules, which replaces the flags that set the policy",
+			EnvVar: "GC_CONFIG",
 		},
 		cli.StringFlag{
 			Name:   "exclude, e",
 			Value:  "",
-			Usage:  "the list of containers to exclude from garbage collection, as a file or directory",
+			Usage:  "the list of resources to exclude from garbage collection, as a file or a directory of files. Entries may be globs or re: regular expressions",
 			EnvVar: "EXCLUDE_FROM_GC",
 		},
+		cli.StringSliceFlag{
+			Name:   "label-filter",
+			Usage:  "only collect resources whose labels match, as key, key=value, !key or key!=value. May be repeated",
+			EnvVar: "GC_LABEL_FILTER",
+		},
+		cli.IntFlag{
+			Name:   "keep-recent",
+			Value:  0,
+			Usage:  "keep the newest N images of every repository regardless of age, older ones are still subject to grace. 0 to disable",
+			EnvVar: "GC_KEEP_RECENT",
+		},
+		cli.StringSliceFlag{
+			Name:   "keep-recent-for",
+			Usage:  "keep the newest N images of repositories matching a glob, as pattern=N. Takes precedence over --keep-recent. May be repeated",
+			EnvVar: "GC_KEEP_RECENT_FOR",
+		},
+		cli.StringFlag{
+			Name:   "high-watermark",
+			Value:  "",
+			Usage:  "only collect once disk usage reaches this percentage (\"85%\") or size (\"50GB\")",
+			EnvVar: "GC_HIGH_WATERMARK",
+		},
+		cli.StringFlag{
+			Name:   "low-watermark",
+			Value:  "",
+			Usage:  "stop collecting once disk usage falls below this percentage or size. Defaults to --high-watermark",
+			EnvVar: "GC_LOW_WATERMARK",
+		},
+		cli.StringFlag{
+			Name:   "docker-root",
+			Value:  "/var/lib/docker",
+			Usage:  "the docker root directory, whose disk is measured for percentage watermarks on local sockets",
+			EnvVar: "DOCKER_ROOT",
+		},
+		cli.StringFlag{
+			Name:   "last-used-file",
+			Value:  "",
+			Usage:  "a state file recording when images were last used, so their grace period is measured from last use. Kept up to date from the events stream in --schedule mode. Defaults to last-used.json in --state-dir",
+			EnvVar: "GC_LAST_USED_FILE",
+		},
+		cli.StringFlag{
+			Name:   "state-dir",
+			Value:  "",
+			Usage:  "a directory recording when resources were first seen unused across runs, so their grace period is measured from then",
+			EnvVar: "GC_STATE_DIR",
+		},
+		cli.StringFlag{
+			Name:   "metrics-file",
+			Usage:  "write Prometheus metrics to this file after every pass, for the node exporter's textfile collector",
+			EnvVar: "GC_METRICS_FILE",
+		},
+		cli.StringFlag{
+			Name:   "metrics-listen",
+			Usage:  "serve Prometheus metrics on /metrics at this address while running on a --schedule",
+			EnvVar: "GC_METRICS_LISTEN",
+		},
+		cli.StringFlag{
+			Name:   "output, o",
+			Value:  outputText,
+			Usage:  "format of the results printed to stdout: " + strings.Join(outputFormats, ", "),
+			EnvVar: "GC_OUTPUT",
+		},
 		cli.BoolFlag{
 			Name:  "quiet, q",
 			Usage: "don't print name of garbage-collected containers",
@@ -210,13 +403,103 @@ func main() {
 			Name:  "remove-volumes, r",
 			Usage: "remove volumes with the container",
 		},
+		cli.BoolFlag{
+			Name:   "volumes",
+			Usage:  "also collect dangling volumes that no container mounts",
+			EnvVar: "GC_VOLUMES",
+		},
+		cli.StringSliceFlag{
+			Name:   "volume-label",
+			Usage:  "only collect volumes with this label, as key or key=value. May be repeated",
+			EnvVar: "GC_VOLUME_LABEL",
+		},
+		cli.BoolFlag{
+			Name:   "networks",
+			Usage:  "also collect user-defined networks that have no containers attached",
+			EnvVar: "GC_NETWORKS",
+		},
+		cli.StringFlag{
+			Name:   "states",
+			Value:  gc.DefaultStates,
+			Usage:  "the container states to collect, separated by commas. Containers in other states, such as running, are never collected",
+			EnvVar: "GC_STATES",
+		},
 		cli.BoolFlag{
 			Name:  "force, f",
 			Usage: "force images and containers to stop and be collected",
 		},
 		cli.BoolFlag{
-			Name:  "no-prune, n",
-			Usage: "don't prune parent images to a GC'd image",
+			Name:  "dry-run",
+			Usage: "print a plan of what would be collected and why, without deleting anything",
+		},
+		cli.BoolFlag{
+			Name:   "no-prune, n",
+			Usage:  "no effect, parent images are only deleted when the policy collects them",
+			Hidden: true,
+		},
+	}
+	dgc.Commands = []cli.Command{
+		{
+			Name:   "watch",
+			Usage:  "collect containers a grace period after they exit, by following the events stream",
+			Action: runWatch,
+		},
+		{
+			Name:   "serve",
+			Usage:  "run as a long-lived service, controlled through a local HTTP API",
+			Action: runServe,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "listen",
+					Value:  "127.0.0.1:8080",
+					Usage:  "address the HTTP API listens on",
+					EnvVar: "GC_LISTEN",
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "work with policy files",
+			Subcommands: []cli.Command{
+				{
+					Name:      "validate",
+					Usage:     "check a policy file and summarize its rules",
+					ArgsUsage: "[file]",
+					Action:    runConfigValidate,
+				},
+			},
+		},
+		{
+			Name:      "explain",
+			Usage:     "print why the policy keeps or deletes a container, image, volume or network",
+			ArgsUsage: "<id|name|tag>",
+			Action:    runExplain,
+		},
+		{
+			Name:   "snapshot",
+			Usage:  "write the containers, images, volumes and networks of a docker host to a JSON file",
+			Action: runSnapshot,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "file to write the snapshot to, instead of stdout",
+				},
+			},
+		},
+		{
+			Name:   "simulate",
+			Usage:  "print what a policy would delete from a snapshot, without a docker host",
+			Action: runSimulate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "snapshot",
+					Usage: "snapshot file written by dgc snapshot",
+				},
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "YAML policy file to simulate, instead of the global flags",
+				},
+			},
 		},
 	}
 	dgc.Run(os.Args)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
+)
+
+// runExplain evaluates the resources an ID, name or tag refers to against the policy on
+// every docker host, printing each check that applies to them and the final decision.
+func runExplain(ctx *cli.Context) error {
+	ref := ctx.Args().First()
+	// The policy and docker hosts are set by the global flags
+	ctx = ctx.Parent()
+	if ref == "" {
+		return cli.NewExitError("Error. dgc explain needs the ID, name or tag of a resource", 1)
+	}
+	format := ctx.String("output")
+	if format != outputText && format != outputJSON {
+		return cli.NewExitError(fmt.Sprintf("Error. dgc explain only supports %s and %s output", outputText, outputJSON), 1)
+	}
+
+	sockets, err := dockerSockets(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	policy, err := loadPolicy(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// Explaining is a dry run that never saves the state, so it doesn't wait for the
+	// lock of a dgc that is running
+	usage, state, err := openTracking(ctx, true)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer state.Close()
+
+	var explanations []gc.Explanation
+	for _, socket := range sockets {
+		client, err := newDockerClient(socket, tlsOptions(ctx))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Error. Failed to create a docker client to: %s: %s", socket, err), 1)
+		}
+		collector := newCollector(socket, client, policy)
+		collector.Usage = usage
+		collector.State = state
+		explained, err := collector.Explain(ref)
+		client.Close()
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Error. Failed to explain %s on %s: %s", ref, socket, err), 1)
+		}
+		explanations = append(explanations, explained...)
+	}
+	if len(explanations) == 0 {
+		return cli.NewExitError(fmt.Sprintf("Error. No container, image, volume or network matches %s", ref), 1)
+	}
+
+	if format == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(explanations); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Error. Failed to write output: %s", err), 1)
+		}
+		return nil
+	}
+	for i, x := range explanations {
+		if i > 0 {
+			fmt.Println()
+		}
+		printExplanation(hostPrefix(x.Socket, len(sockets)), x)
+	}
+	return nil
+}
+
+// printExplanation prints the checks that apply to a resource, marking those that keep
+// it, followed by the decision.
+func printExplanation(prefix string, x gc.Explanation) {
+	names := strings.Join(x.Names, ",")
+	if names == "" {
+		names = "<none>"
+	}
+	fmt.Printf(prefix+"%s %s %s\n", x.Kind, x.ID, names)
+	for _, check := range x.Checks {
+		effect := "passes"
+		if check.Keeps {
+			effect = "keeps"
+		}
+		fmt.Printf(prefix+"  %-10s %-6s %s\n", check.Name, effect, check.Detail)
+	}
+	decision := "kept"
+	if x.Decision == gc.DecisionPlanned {
+		decision = "would be deleted"
+	}
+	fmt.Printf(prefix+"Decision: %s (%s): %s\n", decision, x.Reason, x.Detail)
+}
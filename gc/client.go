@@ -0,0 +1,74 @@
+package gc
+
+import (
+	"context"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+	dockerClient "github.com/docker/docker/client"
+)
+
+// engineClient adapts the Engine API client, whose methods take the vendored
+// golang.org/x/net/context rather than context, to the Client interface.
+type engineClient struct {
+	client *dockerClient.Client
+}
+
+// NewClient returns a Client that talks to the docker daemon through client.
+func NewClient(client *dockerClient.Client) Client {
+	return engineClient{client}
+}
+
+func (c engineClient) ContainerList(ctx context.Context, options dockerTypes.ContainerListOptions) ([]dockerTypes.Container, error) {
+	return c.client.ContainerList(ctx, options)
+}
+
+func (c engineClient) ContainerInspect(ctx context.Context, containerID string) (dockerTypes.ContainerJSON, error) {
+	return c.client.ContainerInspect(ctx, containerID)
+}
+
+func (c engineClient) ContainerRemove(ctx context.Context, containerID string, options dockerTypes.ContainerRemoveOptions) error {
+	return c.client.ContainerRemove(ctx, containerID, options)
+}
+
+func (c engineClient) ImageList(ctx context.Context, options dockerTypes.ImageListOptions) ([]dockerTypes.ImageSummary, error) {
+	return c.client.ImageList(ctx, options)
+}
+
+func (c engineClient) ImageRemove(ctx context.Context, imageID string, options dockerTypes.ImageRemoveOptions) ([]dockerTypes.ImageDeleteResponseItem, error) {
+	return c.client.ImageRemove(ctx, imageID, options)
+}
+
+func (c engineClient) VolumeList(ctx context.Context, filter filters.Args) (volumeTypes.VolumesListOKBody, error) {
+	return c.client.VolumeList(ctx, filter)
+}
+
+func (c engineClient) VolumeInspectWithRaw(ctx context.Context, volumeID string) (dockerTypes.Volume, []byte, error) {
+	return c.client.VolumeInspectWithRaw(ctx, volumeID)
+}
+
+func (c engineClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	return c.client.VolumeRemove(ctx, volumeID, force)
+}
+
+func (c engineClient) NetworkList(ctx context.Context, options dockerTypes.NetworkListOptions) ([]dockerTypes.NetworkResource, error) {
+	return c.client.NetworkList(ctx, options)
+}
+
+func (c engineClient) NetworkInspect(ctx context.Context, networkID string, verbose bool) (dockerTypes.NetworkResource, error) {
+	return c.client.NetworkInspect(ctx, networkID, verbose)
+}
+
+func (c engineClient) NetworkRemove(ctx context.Context, networkID string) error {
+	return c.client.NetworkRemove(ctx, networkID)
+}
+
+func (c engineClient) DiskUsage(ctx context.Context) (dockerTypes.DiskUsage, error) {
+	return c.client.DiskUsage(ctx)
+}
+
+func (c engineClient) Events(ctx context.Context, options dockerTypes.EventsOptions) (<-chan events.Message, <-chan error) {
+	return c.client.Events(ctx, options)
+}
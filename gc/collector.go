@@ -0,0 +1,363 @@
+// Package gc collects the garbage docker leaves behind: stopped containers, unused
+// images, dangling volumes and unused networks. A Collector applies a Policy to a
+// single docker host and returns a Result describing every decision it made.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+)
+
+// Client is the part of the Engine API the collector uses. NewClient adapts a
+// *client.Client from github.com/docker/docker/client to it.
+type Client interface {
+	ContainerList(ctx context.Context, options dockerTypes.ContainerListOptions) ([]dockerTypes.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (dockerTypes.ContainerJSON, error)
+	ContainerRemove(ctx context.Context, containerID string, options dockerTypes.ContainerRemoveOptions) error
+	ImageList(ctx context.Context, options dockerTypes.ImageListOptions) ([]dockerTypes.ImageSummary, error)
+	ImageRemove(ctx context.Context, imageID string, options dockerTypes.ImageRemoveOptions) ([]dockerTypes.ImageDeleteResponseItem, error)
+	VolumeList(ctx context.Context, filter filters.Args) (volumeTypes.VolumesListOKBody, error)
+	VolumeInspectWithRaw(ctx context.Context, volumeID string) (dockerTypes.Volume, []byte, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+	NetworkList(ctx context.Context, options dockerTypes.NetworkListOptions) ([]dockerTypes.NetworkResource, error)
+	NetworkInspect(ctx context.Context, networkID string, verbose bool) (dockerTypes.NetworkResource, error)
+	NetworkRemove(ctx context.Context, networkID string) error
+	DiskUsage(ctx context.Context) (dockerTypes.DiskUsage, error)
+	Events(ctx context.Context, options dockerTypes.EventsOptions) (<-chan events.Message, <-chan error)
+}
+
+// Collector collects garbage from a single docker host.
+type Collector struct {
+	Client Client
+	// Socket identifies the host in the Result and in the usage and state records.
+	Socket string
+	Policy *Policy
+	// Log receives progress and errors, nothing is logged if it is nil.
+	Log *log.Logger
+	// Workers runs the deletions, one at a time if it is nil.
+	Workers *HostWorkers
+	// Usage records when images were last used, nil unless that is tracked.
+	Usage *LastUsed
+	// State records when resources were first seen unused, nil unless that is tracked.
+	State *FirstSeen
+	// OnRecord, if set, is called with every decision as it is made.
+	OnRecord func(Record)
+}
+
+// dockerHost is a single docker daemon that garbage is collected from, for the
+// duration of a pass.
+type dockerHost struct {
+	socket string
+	client Client
+	log    *log.Logger
+	policy *Policy
+	// dryRun plans what would be collected instead of deleting it.
+	dryRun bool
+	// workers runs deletions on the worker pool shared by every host.
+	workers *HostWorkers
+	// budget is how much disk space is left to reclaim, nil unless collection is
+	// driven by watermarks.
+	budget *reclaimBudget
+	// usage records when images were last used, nil unless that is tracked.
+	usage *LastUsed
+	// state records when resources were first seen unused, nil unless that is tracked.
+	state *FirstSeen
+	// onRecord is called with every decision as it is made, if set.
+	onRecord func(Record)
+}
+
+// host returns the docker host the collector works on.
+func (c *Collector) host() *dockerHost {
+	logger := c.Log
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+	policy := c.Policy
+	if policy == nil {
+		policy = &Policy{}
+	}
+	return &dockerHost{
+		socket:   c.Socket,
+		client:   c.Client,
+		log:      logger,
+		policy:   policy,
+		dryRun:   policy.DryRun,
+		workers:  c.Workers,
+		usage:    c.Usage,
+		state:    c.State,
+		onRecord: c.OnRecord,
+	}
+}
+
+// The decisions made about examined resources.
+const (
+	DecisionDeleted = "deleted"
+	DecisionPlanned = "planned"
+	DecisionKept    = "kept"
+	DecisionFailed  = "failed"
+)
+
+// Record is the decision made about a single examined resource.
+type Record struct {
+	Kind     string   `json:"type"`
+	ID       string   `json:"id"`
+	Names    []string `json:"names,omitempty"`
+	Decision string   `json:"decision"`
+	// Reason is a short name for why the decision was made, such as "grace" or
+	// "label", and Detail describes it.
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TypeResult tallies what a collection pass did to one type of resource.
+type TypeResult struct {
+	// Kind is the type of resource: container, image, volume or network.
+	Kind     string `json:"-"`
+	Examined int    `json:"examined"`
+	Deleted  int    `json:"deleted"`
+	Failed   int    `json:"failed"`
+	// Skipped counts the resources that were kept, by the reason they were kept.
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// Reclaimed is the number of bytes freed by the deleted resources.
+	Reclaimed int64 `json:"reclaimed"`
+	// Planned holds the resources a dry run would have deleted.
+	Planned []Candidate `json:"planned,omitempty"`
+	// Records holds the decision made about every examined resource.
+	Records []Record `json:"-"`
+
+	// onRecord is called with every decision as it is made, if set.
+	onRecord func(Record)
+}
+
+// newTypeResult returns an empty result for the kind of resource on the host.
+func newTypeResult(host *dockerHost, kind string) TypeResult {
+	return TypeResult{Kind: kind, onRecord: host.onRecord}
+}
+
+// decide records the decision made about a resource.
+func (r *TypeResult) decide(c Candidate, decision, reason, detail string, err error) {
+	rec := Record{
+		Kind:     c.Kind,
+		ID:       c.ID,
+		Names:    c.Names,
+		Decision: decision,
+		Reason:   reason,
+		Detail:   detail,
+		Size:     c.Size,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	r.Records = append(r.Records, rec)
+	if r.onRecord != nil {
+		r.onRecord(rec)
+	}
+}
+
+// keep records a resource that was kept for the given reason, such as "grace".
+func (r *TypeResult) keep(c Candidate, reason, detail string) {
+	if r.Skipped == nil {
+		r.Skipped = make(map[string]int)
+	}
+	r.Skipped[reason]++
+	r.decide(c, DecisionKept, reason, detail, nil)
+}
+
+// plan records a resource that a dry run would have deleted.
+func (r *TypeResult) plan(c Candidate) {
+	r.Planned = append(r.Planned, c)
+	r.decide(c, DecisionPlanned, c.Reason, c.Rule, nil)
+}
+
+// deleted records a resource that was deleted.
+func (r *TypeResult) deleted(c Candidate) {
+	r.Deleted++
+	r.Reclaimed += c.Size
+	r.decide(c, DecisionDeleted, c.Reason, c.Rule, nil)
+}
+
+// failed records a resource that could not be deleted.
+func (r *TypeResult) failed(c Candidate, err error) {
+	r.Failed++
+	r.decide(c, DecisionFailed, c.Reason, c.Rule, err)
+}
+
+// Kept returns the number of resources that were examined but neither deleted, planned
+// for deletion, nor failed to delete.
+func (r TypeResult) Kept() int {
+	return r.Examined - r.Deleted - r.Failed - len(r.Planned)
+}
+
+// Result is the outcome of collecting garbage from a single host.
+type Result struct {
+	Socket     string
+	Containers TypeResult
+	Images     TypeResult
+	Volumes    TypeResult
+	Networks   TypeResult
+	// Before and After are the disk usage reported by the daemon around the pass, nil
+	// if it couldn't be measured.
+	Before *DiskSnapshot
+	After  *DiskSnapshot
+	Err    error
+}
+
+// Failed reports whether anything went wrong while collecting from the host.
+func (r Result) Failed() bool {
+	return r.Err != nil || r.Containers.Failed > 0 || r.Images.Failed > 0 ||
+		r.Volumes.Failed > 0 || r.Networks.Failed > 0
+}
+
+// Planned returns every resource a dry run would have deleted from the host, in the
+// order it would have deleted them.
+func (r Result) Planned() []Candidate {
+	var planned []Candidate
+	for _, t := range r.ByType() {
+		planned = append(planned, t.Planned...)
+	}
+	return planned
+}
+
+// ByType returns the results for each type of resource, in the order they are collected.
+func (r Result) ByType() []TypeResult {
+	return []TypeResult{r.Containers, r.Images, r.Volumes, r.Networks}
+}
+
+// newResult returns an empty result for the host.
+func newResult(host *dockerHost) Result {
+	return Result{
+		Socket:     host.socket,
+		Containers: newTypeResult(host, "container"),
+		Images:     newTypeResult(host, "image"),
+		Volumes:    newTypeResult(host, "volume"),
+		Networks:   newTypeResult(host, "network"),
+	}
+}
+
+// Collect performs garbage collection on the docker host, or only plans it if the
+// policy is a dry run. Containers are collected first so that the images they used can
+// be collected afterwards. When the policy has watermarks, nothing is collected until
+// disk usage crosses the high watermark, and collection stops once it falls below the
+// low watermark.
+func (c *Collector) Collect() Result {
+	host := c.host()
+	policy := host.policy
+	result := newResult(host)
+	// Container sizes are needed to report how much space deleting them reclaims
+	listOptions := dockerTypes.ContainerListOptions{
+		All:  true,
+		Size: true,
+	}
+
+	if policy.Watermarks != nil {
+		budget, collect, err := newReclaimBudget(host, policy.Watermarks)
+		if err != nil {
+			result.Err = fmt.Errorf("Error. Failed to measure disk usage on the docker host: %s", err)
+			return result
+		}
+		if !collect {
+			return result
+		}
+		host.budget = budget
+	}
+
+	// Measure disk usage before collecting, which also tells the exclusive image sizes
+	before, sizes, err := snapshotDisk(host)
+	if err != nil {
+		host.log.Printf("Error. Failed to measure disk usage on the docker host: %s\n", err)
+	}
+	result.Before = before
+
+	host.log.Println("Getting a list of containers...")
+	containers, err := host.client.ContainerList(context.Background(), listOptions)
+	if err != nil {
+		result.Err = fmt.Errorf("Error. Failed to retrieve containers from the docker host: %s", err)
+		return result
+	}
+
+	for _, container := range containers {
+		host.usage.record(host.socket, container.ImageID, time.Unix(container.Created, 0))
+	}
+
+	host.log.Println("Performing garbage collection...")
+	result.Containers = collectAPIContainers(containers, host)
+
+	if !host.dryRun {
+		if err := host.budget.refresh(host); err != nil {
+			result.Err = fmt.Errorf("Error. Failed to measure disk usage on the docker host: %s", err)
+			return result
+		}
+	}
+
+	// Take a fresh look at which images are in use now that containers are gone
+	host.log.Println("Getting a List of images...")
+	images, err := host.client.ImageList(context.Background(), dockerTypes.ImageListOptions{All: true})
+	if err != nil {
+		result.Err = fmt.Errorf("Error. Failed to retrieve images from the docker host: %s", err)
+		return result
+	}
+	host.usage.forget(host.socket, images)
+
+	removed := make(map[string]bool)
+	if host.dryRun {
+		// Nothing was deleted, so pretend the planned containers are gone
+		for _, c := range result.Containers.Planned {
+			removed[c.ID] = true
+		}
+	} else {
+		// Only the images and volumes the containers use are needed, not their sizes
+		containers, err = host.client.ContainerList(context.Background(), dockerTypes.ContainerListOptions{All: true})
+		if err != nil {
+			result.Err = fmt.Errorf("Error. Failed to retrieve containers from the docker host: %s", err)
+			return result
+		}
+	}
+
+	result.Images = collectAPIImages(images, referencedImages(containers, removed), sizes.images, host)
+
+	if policy.Volumes {
+		if !host.dryRun {
+			if err := host.budget.refresh(host); err != nil {
+				result.Err = fmt.Errorf("Error. Failed to measure disk usage on the docker host: %s", err)
+				return result
+			}
+		}
+
+		host.log.Println("Getting a list of dangling volumes...")
+		volumes, err := listDanglingVolumes(host)
+		if err != nil {
+			result.Err = fmt.Errorf("Error. Failed to retrieve volumes from the docker host: %s", err)
+			return result
+		}
+		result.Volumes = collectAPIVolumes(volumes, mountedVolumes(containers, removed), sizes.volumes, host)
+	}
+
+	if policy.Networks {
+		host.log.Println("Getting a list of networks...")
+		networks, err := host.client.NetworkList(context.Background(), dockerTypes.NetworkListOptions{})
+		if err != nil {
+			result.Err = fmt.Errorf("Error. Failed to retrieve networks from the docker host: %s", err)
+			return result
+		}
+		result.Networks = collectAPINetworks(networks, removed, host)
+	}
+
+	if !host.dryRun && before != nil {
+		if result.After, _, err = snapshotDisk(host); err != nil {
+			host.log.Printf("Error. Failed to measure disk usage on the docker host: %s\n", err)
+		}
+	}
+
+	host.log.Println("Finished garbage collection!")
+	return result
+}
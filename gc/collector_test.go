@@ -0,0 +1,385 @@
+package gc
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hatchery/dgc/gc/gctest"
+)
+
+// ago returns the time d before now.
+func ago(d time.Duration) time.Time {
+	return time.Now().Add(-d)
+}
+
+// newTestCollector returns a collector for the fake daemon that applies policy.
+func newTestCollector(t *testing.T, server *gctest.Server, policy *Policy) *Collector {
+	client := server.Client()
+	t.Cleanup(func() { client.Close() })
+	return &Collector{Client: NewClient(client), Socket: server.URL, Policy: policy}
+}
+
+// decisions returns the decision made about every resource in result, by ID.
+func decisions(result Result) map[string]string {
+	decided := make(map[string]string)
+	for _, t := range result.ByType() {
+		for _, rec := range t.Records {
+			decided[rec.ID] = rec.Decision + " " + rec.Reason
+		}
+	}
+	return decided
+}
+
+// expectDecisions fails the test unless every resource in want was decided as given.
+func expectDecisions(t *testing.T, result Result, want map[string]string) {
+	t.Helper()
+	got := decisions(result)
+	for id, decision := range want {
+		if got[id] != decision {
+			t.Errorf("%s: got decision %q, want %q", id, got[id], decision)
+		}
+	}
+}
+
+// containerIDs returns the IDs of the containers left on the fake daemon, sorted.
+func containerIDs(server *gctest.Server) []string {
+	var ids []string
+	for _, c := range server.Containers() {
+		ids = append(ids, c.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestCollectContainers(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "old", State: "exited", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour), SizeRw: 100})
+	server.AddContainer(gctest.Container{ID: "recent", State: "exited", Created: ago(3 * time.Hour), Finished: ago(time.Minute)})
+	server.AddContainer(gctest.Container{ID: "running", State: "running", Created: ago(3 * time.Hour)})
+	server.AddContainer(gctest.Container{ID: "kept", State: "exited", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour),
+		Labels: map[string]string{"dgc.keep": "true"}})
+	server.AddContainer(gctest.Container{ID: "excluded", Image: "postgres:9", State: "dead", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour)})
+
+	pattern, err := parseExcludePattern("postgres:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates), Excludes: ExcludeList{pattern}}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	expectDecisions(t, result, map[string]string{
+		"old":      "deleted grace",
+		"recent":   "kept grace",
+		"running":  "kept state",
+		"kept":     "kept label",
+		"excluded": "kept excluded",
+	})
+	if result.Containers.Deleted != 1 || result.Containers.Reclaimed != 100 {
+		t.Errorf("got %d deleted and %d bytes reclaimed, want 1 and 100", result.Containers.Deleted, result.Containers.Reclaimed)
+	}
+	if got, want := containerIDs(server), []string{"excluded", "kept", "recent", "running"}; !equalStrings(got, want) {
+		t.Errorf("got containers %v left, want %v", got, want)
+	}
+}
+
+func TestCollectImages(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddImage(gctest.Image{ID: "sha256:unused", RepoTags: []string{"app:1"}, Created: ago(48 * time.Hour), Size: 300, SharedSize: 100})
+	server.AddImage(gctest.Image{ID: "sha256:used", RepoTags: []string{"app:2"}, Created: ago(48 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:new", RepoTags: []string{"app:3"}, Created: ago(time.Minute)})
+	server.AddImage(gctest.Image{ID: "sha256:orphan", Created: ago(48 * time.Hour)})
+	// The container is collected first, after which its image goes too
+	server.AddContainer(gctest.Container{ID: "gone", State: "exited", ImageID: "sha256:orphan", Created: ago(48 * time.Hour), Finished: ago(47 * time.Hour)})
+	server.AddContainer(gctest.Container{ID: "web", State: "running", ImageID: "sha256:used", Created: ago(time.Hour)})
+
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates)}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	expectDecisions(t, result, map[string]string{
+		"gone":          "deleted grace",
+		"sha256:unused": "deleted grace",
+		"sha256:orphan": "deleted grace",
+		"sha256:used":   "kept in_use",
+		"sha256:new":    "kept grace",
+	})
+	// Only the bytes the image doesn't share with others are reclaimed
+	if result.Images.Reclaimed != 200 {
+		t.Errorf("got %d bytes reclaimed, want 200", result.Images.Reclaimed)
+	}
+	if images := server.Images(); len(images) != 2 {
+		t.Errorf("got %d images left, want 2", len(images))
+	}
+}
+
+func TestCollectImagesKeptParent(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddImage(gctest.Image{ID: "sha256:base", Created: ago(48 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:app", ParentID: "sha256:base", RepoTags: []string{"app:1"}, Created: ago(48 * time.Hour)})
+
+	pattern, err := parseExcludePattern("sha256:base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates), Excludes: ExcludeList{pattern}}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	// Deleting the child must not take the untagged parent the policy keeps with it
+	expectDecisions(t, result, map[string]string{
+		"sha256:app":  "deleted grace",
+		"sha256:base": "kept excluded",
+	})
+	if images := server.Images(); len(images) != 1 || images[0].ID != "sha256:base" {
+		t.Errorf("got images %+v left, want sha256:base", images)
+	}
+}
+
+func TestCollectRetention(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddImage(gctest.Image{ID: "sha256:0", RepoTags: []string{"app:0"}, Created: ago(48 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:1", RepoTags: []string{"app:1"}, Created: ago(2 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:2", RepoTags: []string{"app:2"}, Created: ago(2 * time.Minute)})
+	server.AddImage(gctest.Image{ID: "sha256:3", RepoTags: []string{"app:3"}, Created: ago(time.Minute)})
+
+	retention, err := ParseRetentionRules(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{Grace: 24 * time.Hour, States: ParseStates(DefaultStates), Retention: retention}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	// The newest two stay, and the older ones still wait out the grace period
+	expectDecisions(t, result, map[string]string{
+		"sha256:0": "deleted grace",
+		"sha256:1": "kept grace",
+		"sha256:2": "kept retention",
+		"sha256:3": "kept retention",
+	})
+}
+
+func TestCollectVolumesAndNetworks(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddVolume(gctest.Volume{Name: "dangling", Created: ago(2 * time.Hour), Size: 50})
+	server.AddVolume(gctest.Volume{Name: "fresh", Created: ago(time.Minute)})
+	server.AddVolume(gctest.Volume{Name: "mounted", Created: ago(2 * time.Hour)})
+	server.AddNetwork(gctest.Network{ID: "n1", Name: "bridge", Created: ago(48 * time.Hour)})
+	server.AddNetwork(gctest.Network{ID: "n2", Name: "unused", Created: ago(2 * time.Hour)})
+	server.AddNetwork(gctest.Network{ID: "n3", Name: "attached", Created: ago(2 * time.Hour)})
+	server.AddContainer(gctest.Container{ID: "db", State: "running", Created: ago(time.Hour),
+		Volumes: []string{"mounted"}, Networks: []string{"attached"}})
+
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates), Volumes: true, Networks: true}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	// The dangling filter leaves out mounted volumes before the collector sees them
+	expectDecisions(t, result, map[string]string{
+		"dangling": "deleted grace",
+		"fresh":    "kept grace",
+		"n1":       "kept builtin",
+		"n2":       "deleted grace",
+		"n3":       "kept in_use",
+	})
+	if len(server.Volumes()) != 2 || len(server.Networks()) != 2 {
+		t.Errorf("got %d volumes and %d networks left, want 2 and 2", len(server.Volumes()), len(server.Networks()))
+	}
+}
+
+func TestCollectVolumesWatermark(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddVolume(gctest.Volume{Name: "a", Created: ago(4 * time.Hour), Size: 100})
+	server.AddVolume(gctest.Volume{Name: "b", Created: ago(3 * time.Hour), Size: 100})
+	server.AddVolume(gctest.Volume{Name: "c", Created: ago(2 * time.Hour), Size: 100})
+
+	marks, err := ParseWatermarks("250B", "200B", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates), Volumes: true, Watermarks: marks}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	// Deleting the oldest volume is enough to fall below the low watermark
+	expectDecisions(t, result, map[string]string{
+		"a": "deleted grace",
+		"b": "kept watermark",
+		"c": "kept watermark",
+	})
+	if result.Volumes.Deleted != 1 || result.Volumes.Reclaimed != 100 {
+		t.Errorf("got %d deleted and %d bytes reclaimed, want 1 and 100", result.Volumes.Deleted, result.Volumes.Reclaimed)
+	}
+}
+
+func TestCollectDryRun(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "old", State: "exited", ImageID: "sha256:old", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:old", RepoTags: []string{"app:1"}, Created: ago(3 * time.Hour)})
+
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates), DryRun: true}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	// The image is planned too, since the container using it would be gone
+	expectDecisions(t, result, map[string]string{
+		"old":        "planned grace",
+		"sha256:old": "planned grace",
+	})
+	if planned := result.Planned(); len(planned) != 2 {
+		t.Errorf("got %d resources planned, want 2", len(planned))
+	}
+	for _, request := range server.Requests() {
+		if request[:7] == "DELETE " {
+			t.Errorf("dry run made request %s", request)
+		}
+	}
+}
+
+func TestCollectScriptedFailures(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "a", State: "exited", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour)})
+	server.AddContainer(gctest.Container{ID: "b", State: "exited", Created: ago(3 * time.Hour), Finished: ago(3 * time.Hour)})
+	server.Script(gctest.Fault{Method: http.MethodDelete, Path: "/containers/b", Status: http.StatusInternalServerError, Message: "driver failed"})
+
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates)}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	expectDecisions(t, result, map[string]string{
+		"a": "deleted grace",
+		"b": "failed grace",
+	})
+	if !result.Failed() || result.Containers.Failed != 1 {
+		t.Errorf("got %d failed, want the host to have failed once", result.Containers.Failed)
+	}
+	if got, want := containerIDs(server), []string{"b"}; !equalStrings(got, want) {
+		t.Errorf("got containers %v left, want %v", got, want)
+	}
+
+	// A daemon that can't list containers fails the whole pass
+	server.Script(gctest.Fault{Path: "/containers/json", Status: http.StatusInternalServerError, Message: "overloaded", Times: 1})
+	if result := newTestCollector(t, server, policy).Collect(); result.Err == nil {
+		t.Error("got no error when listing containers failed")
+	}
+}
+
+func TestCollectConcurrentDeletions(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		server.AddContainer(gctest.Container{ID: id, State: "exited", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour)})
+	}
+	delay := 200 * time.Millisecond
+	server.Script(gctest.Fault{Method: http.MethodDelete, Path: "/containers/*", Delay: delay})
+
+	workers := NewWorkerPool(4).ForHost(0, 0)
+	defer workers.Stop()
+	collector := newTestCollector(t, server, &Policy{Grace: time.Hour, States: ParseStates(DefaultStates)})
+	collector.Workers = workers
+
+	started := time.Now()
+	result := collector.Collect()
+	if result.Containers.Deleted != 4 {
+		t.Fatalf("got %d deleted, want 4", result.Containers.Deleted)
+	}
+	// One at a time the deletions would take four times the delay
+	if elapsed := time.Since(started); elapsed >= 3*delay {
+		t.Errorf("deletions took %s, want them to run concurrently", elapsed)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "job", State: "running", Created: ago(time.Minute), SizeRw: 100})
+	server.AddContainer(gctest.Container{ID: "service", State: "running", Created: ago(time.Minute),
+		Labels: map[string]string{"dgc.keep": "true"}})
+
+	records := make(chan Record, 4)
+	collector := newTestCollector(t, server, &Policy{States: ParseStates(DefaultStates)})
+	collector.OnRecord = func(rec Record) { records <- rec }
+	stop, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.Watch(stop)
+		close(done)
+	}()
+
+	// Wait for the watcher to follow the events stream before anything exits
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if requested(server, "GET /events") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the watcher never followed the events stream")
+		}
+	}
+	server.SetState("service", "exited")
+	server.SetState("job", "exited")
+
+	select {
+	case rec := <-records:
+		if rec.ID != "job" || rec.Decision != DecisionDeleted || rec.Size != 100 {
+			t.Errorf("got %s %s of %d bytes, want job deleted with its 100 bytes", rec.ID, rec.Decision, rec.Size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the exited container was never collected")
+	}
+	cancel()
+	<-done
+	if got, want := containerIDs(server), []string{"service"}; !equalStrings(got, want) {
+		t.Errorf("got containers %v left, want %v", got, want)
+	}
+}
+
+// requested reports whether the fake daemon has served the request.
+func requested(server *gctest.Server, request string) bool {
+	for _, r := range server.Requests() {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+// equalStrings reports whether a and b hold the same strings in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
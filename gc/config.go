@@ -0,0 +1,218 @@
+package gc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultGrace is the grace period of a policy file that doesn't set one.
+const DefaultGrace = time.Hour
+
+// policyFile is the YAML form of a Policy. Its keys are named after the dgc flags
+// that set the same thing.
+type policyFile struct {
+	Grace         string   `yaml:"grace"`
+	Exclude       []string `yaml:"exclude"`
+	LabelFilter   []string `yaml:"label-filter"`
+	KeepRecent    int      `yaml:"keep-recent"`
+	KeepRecentFor []string `yaml:"keep-recent-for"`
+	States        []string `yaml:"states"`
+	HighWatermark string   `yaml:"high-watermark"`
+	LowWatermark  string   `yaml:"low-watermark"`
+	DockerRoot    string   `yaml:"docker-root"`
+	Volumes       bool     `yaml:"volumes"`
+	VolumeLabel   []string `yaml:"volume-label"`
+	Networks      bool     `yaml:"networks"`
+	RemoveVolumes *bool    `yaml:"remove-volumes"`
+	Force         bool     `yaml:"force"`
+
+	// Rules holds the rules for each type of resource, in the order they are tried.
+	Rules struct {
+		Containers []ruleFile `yaml:"containers"`
+		Images     []ruleFile `yaml:"images"`
+		Volumes    []ruleFile `yaml:"volumes"`
+		Networks   []ruleFile `yaml:"networks"`
+	} `yaml:"rules"`
+}
+
+// ruleFile is the YAML form of a Rule.
+type ruleFile struct {
+	Description string `yaml:"description"`
+	Match       struct {
+		Names     []string `yaml:"names"`
+		Labels    []string `yaml:"labels"`
+		States    []string `yaml:"states"`
+		OlderThan string   `yaml:"older-than"`
+	} `yaml:"match"`
+	Action string `yaml:"action"`
+	After  string `yaml:"after"`
+}
+
+// ParsePolicy parses a policy file. Unknown keys are an error, so that typos don't
+// silently widen what is collected. Anything left out takes the default of its flag.
+// The rules section holds the rules for containers, images, volumes and networks.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var file policyFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{
+		Grace:         DefaultGrace,
+		States:        ParseStates(DefaultStates),
+		Volumes:       file.Volumes,
+		VolumeLabels:  file.VolumeLabel,
+		Networks:      file.Networks,
+		RemoveVolumes: file.RemoveVolumes == nil || *file.RemoveVolumes,
+		Force:         file.Force,
+	}
+	var err error
+	if file.Grace != "" {
+		if policy.Grace, err = time.ParseDuration(file.Grace); err != nil {
+			return nil, fmt.Errorf("invalid grace: %s", err)
+		}
+	}
+	if len(file.States) > 0 {
+		policy.States = ParseStates(strings.Join(file.States, ","))
+	}
+	if policy.Excludes, err = ParseExcludes(file.Exclude); err != nil {
+		return nil, err
+	}
+	for _, filter := range file.LabelFilter {
+		selector, err := ParseLabelSelector(filter)
+		if err != nil {
+			return nil, err
+		}
+		policy.Labels = append(policy.Labels, selector)
+	}
+	if policy.Retention, err = ParseRetentionRules(file.KeepRecent, file.KeepRecentFor); err != nil {
+		return nil, err
+	}
+	root := file.DockerRoot
+	if root == "" {
+		root = "/var/lib/docker"
+	}
+	if policy.Watermarks, err = ParseWatermarks(file.HighWatermark, file.LowWatermark, root); err != nil {
+		return nil, err
+	}
+
+	// Rules for volumes and networks would never be tried unless they are collected
+	if len(file.Rules.Volumes) > 0 && !file.Volumes {
+		return nil, fmt.Errorf("volume rules need volumes: true")
+	}
+	if len(file.Rules.Networks) > 0 && !file.Networks {
+		return nil, fmt.Errorf("network rules need networks: true")
+	}
+	policy.Rules = make(map[string][]Rule)
+	sections := []struct {
+		kind, name string
+		rules      []ruleFile
+	}{
+		{"container", "containers", file.Rules.Containers},
+		{"image", "images", file.Rules.Images},
+		{"volume", "volumes", file.Rules.Volumes},
+		{"network", "networks", file.Rules.Networks},
+	}
+	for _, section := range sections {
+		for i, rf := range section.rules {
+			name := fmt.Sprintf("%s rule %d", section.name, i+1)
+			rule, err := rf.parse(name, section.kind)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			// Containers in other states are kept before any rule is tried
+			for state := range rule.States {
+				if !policy.States[state] {
+					return nil, fmt.Errorf("%s: state %s is never collected, add it to states", name, state)
+				}
+			}
+			policy.Rules[section.kind] = append(policy.Rules[section.kind], rule)
+		}
+	}
+	return policy, nil
+}
+
+// containerStates are the states docker reports containers in.
+var containerStates = map[string]bool{
+	"created":    true,
+	"restarting": true,
+	"running":    true,
+	"removing":   true,
+	"paused":     true,
+	"exited":     true,
+	"dead":       true,
+}
+
+// parse checks a rule for the kind of resource and parses it. The rule is identified
+// by name, which its description is added to.
+func (rf ruleFile) parse(name, kind string) (Rule, error) {
+	rule := Rule{Name: name, Action: rf.Action}
+	if rf.Description != "" {
+		rule.Name += " (" + rf.Description + ")"
+	}
+	var err error
+	if rule.Names, err = ParseExcludes(rf.Match.Names); err != nil {
+		return rule, err
+	}
+	for _, label := range rf.Match.Labels {
+		selector, err := ParseLabelSelector(label)
+		if err != nil {
+			return rule, err
+		}
+		rule.Labels = append(rule.Labels, selector)
+	}
+	if len(rf.Match.States) > 0 {
+		if kind != "container" {
+			return rule, fmt.Errorf("only containers have states")
+		}
+		rule.States = make(map[string]bool)
+		for _, state := range rf.Match.States {
+			state = strings.ToLower(strings.TrimSpace(state))
+			if !containerStates[state] {
+				return rule, fmt.Errorf("unknown container state %q", state)
+			}
+			rule.States[state] = true
+		}
+	}
+	if rf.Match.OlderThan != "" {
+		if rule.OlderThan, err = time.ParseDuration(rf.Match.OlderThan); err != nil {
+			return rule, fmt.Errorf("invalid older-than: %s", err)
+		}
+	}
+
+	switch rule.Action {
+	case ActionKeep, ActionDelete:
+		if rf.After != "" {
+			return rule, fmt.Errorf("after only applies to %s", ActionDeleteAfter)
+		}
+	case ActionDeleteAfter:
+		if rf.After == "" {
+			return rule, fmt.Errorf("%s needs after", ActionDeleteAfter)
+		}
+		if rule.After, err = time.ParseDuration(rf.After); err != nil {
+			return rule, fmt.Errorf("invalid after: %s", err)
+		}
+	case "":
+		return rule, fmt.Errorf("action is required, one of %s, %s or %s", ActionKeep, ActionDelete, ActionDeleteAfter)
+	default:
+		return rule, fmt.Errorf("unknown action %q, expected %s, %s or %s", rule.Action, ActionKeep, ActionDelete, ActionDeleteAfter)
+	}
+	return rule, nil
+}
+
+// ReadPolicy reads a policy file, see ParsePolicy.
+func ReadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return policy, nil
+}
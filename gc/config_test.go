@@ -0,0 +1,19 @@
+package gc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte("grace: 30m\nexclude: [\"postgres:*\"]\nkeep-recent: 2\nremove-volumes: false\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Grace != 30*time.Minute || len(policy.Excludes) != 1 || policy.RemoveVolumes {
+		t.Errorf("got grace %s, %d excludes and remove volumes %t", policy.Grace, len(policy.Excludes), policy.RemoveVolumes)
+	}
+	if _, err := ParsePolicy([]byte("grace: 1h\nnetwork: true\n")); err == nil {
+		t.Error("expected an unknown key to be an error")
+	}
+}
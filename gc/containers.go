@@ -0,0 +1,188 @@
+package gc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	units "github.com/docker/go-units"
+)
+
+// DefaultStates are the container states that are collected unless --states says
+// otherwise. Containers in them are no longer doing any work.
+const DefaultStates = "exited,dead,created"
+
+// ParseStates parses the comma separated --states flag into a set.
+func ParseStates(states string) map[string]bool {
+	parsed := make(map[string]bool)
+	for _, state := range strings.Split(states, ",") {
+		if state = strings.ToLower(strings.TrimSpace(state)); state != "" {
+			parsed[state] = true
+		}
+	}
+	return parsed
+}
+
+// containerKept returns why the container is kept regardless of its age, as a short
+// category such as "label" and a description, or empty strings if it may be collected.
+func containerKept(container dockerTypes.Container, policy *Policy) (string, string) {
+	// Check if the container id, image or name is on excludes list
+	if entry, excluded := policy.Excludes.match(append([]string{container.ID, container.Image}, container.Names...)...); excluded {
+		return "excluded", "excluded by " + entry
+	}
+
+	// Keep the container if its labels protect it or don't match the label filters
+	if reason := policy.keptByLabels(container.Labels); reason != "" {
+		return "label", reason
+	}
+
+	// Only collect containers in one of the selected states
+	if !policy.States[container.State] {
+		return "state", "state " + container.State + " is not collected"
+	}
+	return "", ""
+}
+
+// containerSubject returns what rules are matched against for the container.
+func containerSubject(container dockerTypes.Container) subject {
+	return subject{
+		names:  append([]string{container.ID, container.Image}, container.Names...),
+		labels: container.Labels,
+		state:  container.State,
+	}
+}
+
+// containerFinished returns when the container stopped running. Containers that never
+// ran, or are still running, are measured from when they were created instead.
+func containerFinished(host *dockerHost, container dockerTypes.Container) (time.Time, error) {
+	created := time.Unix(container.Created, 0)
+	inspected, err := host.client.ContainerInspect(context.Background(), container.ID)
+	if err != nil {
+		return created, err
+	}
+	state := inspected.State
+	if state == nil || state.Running || state.Paused || state.Restarting {
+		return created, nil
+	}
+	finished, err := time.Parse(time.RFC3339Nano, state.FinishedAt)
+	if err != nil || finished.Before(created) {
+		return created, nil
+	}
+	return finished, nil
+}
+
+// collectAPIContainers deletes the containers that are in one of the collected states
+// and have been finished for longer than the grace period.
+func collectAPIContainers(containers []dockerTypes.Container, host *dockerHost) TypeResult {
+	var containerSync sync.WaitGroup
+	var resultLock sync.Mutex
+	result := newTypeResult(host, "container")
+	dryRun := host.dryRun
+	options := dockerTypes.ContainerRemoveOptions{
+		RemoveVolumes: host.policy.RemoveVolumes,
+		Force:         host.policy.Force,
+	}
+
+	var candidates []Candidate
+	host.state.check(host.socket, "container")
+	for _, container := range containers {
+		result.Examined++
+		c := Candidate{Kind: "container", ID: container.ID, Names: container.Names, Size: container.SizeRw}
+		if kept, reason := containerKept(container, host.policy); kept != "" {
+			result.keep(c, kept, reason)
+			continue
+		}
+
+		// The container has gone unused since it finished or was first seen unused,
+		// which takes inspecting it to find out
+		now := time.Now()
+		unused := host.state.since(host.socket, "container", container.ID, time.Time{})
+		var finished *time.Time
+		age := func() (time.Duration, error) {
+			if finished == nil {
+				f, err := containerFinished(host, container)
+				if err != nil {
+					return 0, err
+				}
+				finished = &f
+			}
+			return now.Sub(later(*finished, unused)), nil
+		}
+		rule, err := host.policy.matchRule("container", containerSubject(container), age)
+		if err != nil {
+			host.log.Printf("Error. Failed to inspect container: %s: %s\n", container.ID, err)
+			result.keep(c, "inspect_failed", "failed to inspect: "+err.Error())
+			continue
+		}
+		if rule.keeps() {
+			result.keep(c, "rule", ruleKept(rule))
+			continue
+		}
+
+		// Skip the container if it is still in the grace period. A container can't
+		// finish before it was created, so only inspect it if that check passes.
+		grace := rule.graceOf(host.policy.grace(container.Labels))
+		if now.Sub(later(time.Unix(container.Created, 0), unused)) < grace {
+			result.keep(c, "grace", rule.graceKept(grace))
+			continue
+		}
+		c.Age, err = age()
+		if err != nil {
+			host.log.Printf("Error. Failed to inspect container: %s: %s\n", container.ID, err)
+			result.keep(c, "inspect_failed", "failed to inspect: "+err.Error())
+			continue
+		}
+		if c.Age < grace {
+			result.keep(c, "grace", rule.graceKept(grace))
+			continue
+		}
+
+		reason, detail := rule.selected(grace)
+		c.Reason, c.Rule = reason, container.State+", "+detail
+		candidates = append(candidates, c)
+	}
+
+	// Delete the least valuable containers first, until enough disk space is reclaimed
+	sortByValue(candidates)
+	for i, c := range candidates {
+		if host.budget.exhausted() {
+			for _, c := range candidates[i:] {
+				result.keep(c, "watermark", watermarkKept)
+			}
+			break
+		}
+		host.budget.spend(c.Size)
+
+		if dryRun {
+			result.plan(c)
+			continue
+		}
+
+		// Delete container
+		containerSync.Add(1)
+		c, id, size := c, c.ID, c.Size
+		host.workers.Go(func() {
+			defer containerSync.Done()
+
+			host.log.Printf("Deleting container: %s\n", id)
+
+			err := host.client.ContainerRemove(context.Background(), id, options)
+			resultLock.Lock()
+			defer resultLock.Unlock()
+			if err == nil {
+				result.deleted(c)
+				host.log.Printf("Deleted container: %s (%s)\n", id, units.HumanSize(float64(size)))
+			} else {
+				result.failed(c, err)
+				host.budget.spend(-size)
+				host.log.Printf("Error. Failed to delete container: %s: %s\n", id, err)
+				return
+			}
+		})
+	}
+
+	containerSync.Wait()
+	return result
+}
@@ -0,0 +1,58 @@
+package gc
+
+import (
+	"context"
+)
+
+// DiskSnapshot is how much disk space the docker daemon reported using at one moment.
+type DiskSnapshot struct {
+	Images     int64 `json:"images"`
+	Containers int64 `json:"containers"`
+	Volumes    int64 `json:"volumes"`
+}
+
+// Total returns the space used by images, containers and volumes together.
+func (s *DiskSnapshot) Total() int64 {
+	return s.Images + s.Containers + s.Volumes
+}
+
+// diskSizes holds how many bytes deleting each image and volume frees, by image ID and
+// volume name, for those the daemon reported a size for.
+type diskSizes struct {
+	images  map[string]int64
+	volumes map[string]int64
+}
+
+// snapshotDisk asks the daemon how much disk space it is using. It also returns the
+// exclusive size of every image, which leaves out the layers shared with other images
+// since deleting the image doesn't free them, and the size of every volume.
+func snapshotDisk(host *dockerHost) (*DiskSnapshot, diskSizes, error) {
+	sizes := diskSizes{images: make(map[string]int64), volumes: make(map[string]int64)}
+	du, err := host.client.DiskUsage(context.Background())
+	if err != nil {
+		return nil, sizes, err
+	}
+	snapshot := &DiskSnapshot{Images: du.LayersSize}
+	for _, container := range du.Containers {
+		snapshot.Containers += container.SizeRw
+	}
+	for _, volume := range du.Volumes {
+		if volume.UsageData != nil && volume.UsageData.Size > 0 {
+			snapshot.Volumes += volume.UsageData.Size
+			sizes.volumes[volume.Name] = volume.UsageData.Size
+		}
+	}
+	for _, image := range du.Images {
+		sizes.images[image.ID] = exclusiveSize(image.Size, image.SharedSize)
+	}
+	return snapshot, sizes, nil
+}
+
+// exclusiveSize returns the bytes only the image uses. The daemon reports a shared size
+// of -1 when it didn't compute one, in which case the whole image is counted.
+func exclusiveSize(size, shared int64) int64 {
+	if shared < 0 || shared > size {
+		return size
+	}
+	return size - shared
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package gc
+
+import (
+	"syscall"
+)
+
+// statDisk returns the bytes in use on the disk holding path and the size of the disk.
+func statDisk(path string) (used, total int64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	total = int64(stat.Blocks) * int64(stat.Bsize)
+	used = total - int64(stat.Bfree)*int64(stat.Bsize)
+	return used, total, nil
+}
@@ -0,0 +1,10 @@
+package gc
+
+import (
+	"fmt"
+)
+
+// statDisk isn't supported on windows, where percentage watermarks can't be used.
+func statDisk(path string) (used, total int64, err error) {
+	return 0, 0, fmt.Errorf("measuring %s is not supported on windows, use size watermarks", path)
+}
@@ -0,0 +1,140 @@
+package gc
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// regexpPrefix marks an exclude entry as a regular expression.
+const regexpPrefix = "re:"
+
+// excludePattern is a single entry of the exclude list. It matches names exactly,
+// as a glob pattern if it contains any of *?[, or as a regular expression if it
+// starts with re:.
+type excludePattern struct {
+	text   string
+	glob   bool
+	regexp *regexp.Regexp
+}
+
+// parseExcludePattern parses a single line of an exclude file.
+func parseExcludePattern(text string) (excludePattern, error) {
+	pattern := excludePattern{text: text}
+	if strings.HasPrefix(text, regexpPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(text, regexpPrefix))
+		if err != nil {
+			return pattern, err
+		}
+		pattern.regexp = re
+		return pattern, nil
+	}
+	if strings.ContainsAny(text, "*?[") {
+		if _, err := path.Match(text, ""); err != nil {
+			return pattern, err
+		}
+		pattern.glob = true
+	}
+	return pattern, nil
+}
+
+// matches reports whether the pattern matches the name.
+func (p excludePattern) matches(name string) bool {
+	switch {
+	case p.regexp != nil:
+		return p.regexp.MatchString(name)
+	case p.glob:
+		matched, _ := path.Match(p.text, name)
+		return matched
+	}
+	return p.text == name
+}
+
+// ExcludeList holds the resources that must never be collected.
+type ExcludeList []excludePattern
+
+// match returns the first entry that matches any of the names, which are the IDs,
+// names and tags a resource is known by. The second result is false if none match.
+func (l ExcludeList) match(names ...string) (string, bool) {
+	for _, pattern := range l {
+		for _, name := range names {
+			if pattern.matches(name) {
+				return pattern.text, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ReadExcludes reads the exclude list from a file, or from every file in a directory.
+// Blank lines and lines starting with # are ignored.
+func ReadExcludes(fileName string) (ExcludeList, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening exclude file: %s", err)
+	}
+	if !info.IsDir() {
+		return readExcludeFile(fileName)
+	}
+
+	entries, err := ioutil.ReadDir(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading exclude directory: %s", err)
+	}
+	var excludes ExcludeList
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		fileExcludes, err := readExcludeFile(filepath.Join(fileName, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, fileExcludes...)
+	}
+	return excludes, nil
+}
+
+// ParseExcludes parses exclude entries given directly, such as those in a policy file.
+func ParseExcludes(entries []string) (ExcludeList, error) {
+	var excludes ExcludeList
+	for _, text := range entries {
+		pattern, err := parseExcludePattern(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude entry %q: %s", text, err)
+		}
+		excludes = append(excludes, pattern)
+	}
+	return excludes, nil
+}
+
+// readExcludeFile reads the exclude entries from a single file.
+func readExcludeFile(fileName string) (ExcludeList, error) {
+	var excludes ExcludeList
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening exclude file: %s", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pattern, err := parseExcludePattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("Error in exclude file %s line %d: %s", fileName, line, err)
+		}
+		excludes = append(excludes, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading exclude file: %s", err)
+	}
+	return excludes, nil
+}
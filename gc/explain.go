@@ -0,0 +1,405 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	units "github.com/docker/go-units"
+)
+
+// Check is a single part of the policy applied to a resource, and what it made of it.
+type Check struct {
+	// Name is what was checked, such as "exclude", "in_use" or "grace".
+	Name string `json:"check"`
+	// Keeps is set if the check on its own keeps the resource.
+	Keeps  bool   `json:"keeps"`
+	Detail string `json:"detail"`
+}
+
+// Explanation is how the policy applies to a single resource.
+type Explanation struct {
+	Socket string   `json:"socket"`
+	Kind   string   `json:"type"`
+	ID     string   `json:"id"`
+	Names  []string `json:"names,omitempty"`
+	// Checks holds every check that applies to the resource, in the order the
+	// collector makes them.
+	Checks []Check `json:"checks"`
+	// Decision is DecisionPlanned if a pass would delete the resource, and
+	// DecisionKept otherwise, for Reason as described by Detail.
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// explainer applies the policy of a host to single resources.
+type explainer struct {
+	host       *dockerHost
+	containers []dockerTypes.Container
+	images     []dockerTypes.ImageSummary
+	// result is a dry run of the policy, which decides what happens to every resource.
+	result  Result
+	decided map[string]Record
+	// removed holds the containers the dry run would delete.
+	removed map[string]bool
+}
+
+// Explain evaluates every resource that ref refers to against the policy, and returns
+// nothing if there is none. ref may be the ID, name or tag of a container, image,
+// volume or network, or the start of an ID if no name or tag matches. The decisions
+// are those of a dry run, so they account for the containers a pass would delete
+// before looking at images, volumes and networks.
+func (c *Collector) Explain(ref string) ([]Explanation, error) {
+	host := c.host()
+	ctx := context.Background()
+	e := &explainer{host: host, decided: make(map[string]Record), removed: make(map[string]bool)}
+
+	var err error
+	e.containers, err = host.client.ContainerList(ctx, dockerTypes.ContainerListOptions{All: true, Size: true})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve containers from the docker host: %s", err)
+	}
+	if e.images, err = host.client.ImageList(ctx, dockerTypes.ImageListOptions{All: true}); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve images from the docker host: %s", err)
+	}
+	volumes, err := host.client.VolumeList(ctx, filters.NewArgs())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve volumes from the docker host: %s", err)
+	}
+	networks, err := host.client.NetworkList(ctx, dockerTypes.NetworkListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve networks from the docker host: %s", err)
+	}
+
+	// Names and tags are tried before IDs, so that a name that happens to look like the
+	// start of an ID doesn't drag in an unrelated resource
+	var matched []func() Explanation
+	for _, byID := range []bool{false, true} {
+		for _, container := range e.containers {
+			container := container
+			if refersTo(ref, byID, container.ID, container.Names...) {
+				matched = append(matched, func() Explanation { return e.container(container) })
+			}
+		}
+		for _, image := range e.images {
+			image := image
+			if refersTo(ref, byID, image.ID, image.RepoTags...) {
+				matched = append(matched, func() Explanation { return e.image(image) })
+			}
+		}
+		for _, volume := range volumes.Volumes {
+			volume := volume
+			if refersTo(ref, byID, volume.Name, volume.Name) {
+				matched = append(matched, func() Explanation { return e.volume(volume) })
+			}
+		}
+		for _, network := range networks {
+			network := network
+			if refersTo(ref, byID, network.ID, network.Name) {
+				matched = append(matched, func() Explanation { return e.network(network) })
+			}
+		}
+		if len(matched) > 0 {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	// The decisions come from a dry run of the same policy
+	policy := *host.policy
+	policy.DryRun = true
+	dry := *c
+	dry.Policy, dry.Workers, dry.OnRecord = &policy, nil, nil
+	if e.result = dry.Collect(); e.result.Err != nil {
+		return nil, e.result.Err
+	}
+	for _, t := range e.result.ByType() {
+		for _, rec := range t.Records {
+			e.decided[rec.Kind+"/"+rec.ID] = rec
+		}
+	}
+	for _, planned := range e.result.Containers.Planned {
+		e.removed[planned.ID] = true
+	}
+
+	var explanations []Explanation
+	for _, explain := range matched {
+		x := explain()
+		e.decide(&x)
+		explanations = append(explanations, x)
+	}
+	return explanations, nil
+}
+
+// refersTo reports whether ref is one of the names of a resource, or if byID is set,
+// its ID or the start of it. Container names are matched with or without their leading
+// slash, and tags without a version are taken to mean latest.
+func refersTo(ref string, byID bool, id string, names ...string) bool {
+	if byID {
+		short := strings.TrimPrefix(id, "sha256:")
+		return ref == id || strings.HasPrefix(short, strings.TrimPrefix(ref, "sha256:"))
+	}
+	for _, name := range names {
+		if ref == name || "/"+ref == name || ref+":latest" == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decide fills in what a pass would do with the resource. Resources the dry run didn't
+// examine are kept, because their type isn't collected or collection didn't start.
+func (e *explainer) decide(x *Explanation) {
+	policy := e.host.policy
+	if rec, ok := e.decided[x.Kind+"/"+x.ID]; ok {
+		x.Decision, x.Reason, x.Detail = rec.Decision, rec.Reason, rec.Detail
+		if x.Detail == "" {
+			x.Detail = rec.Error
+		}
+		return
+	}
+	x.Decision, x.Reason = DecisionKept, "not_examined"
+	switch {
+	case policy.Watermarks != nil && e.result.Containers.Examined == 0:
+		x.Detail = "disk usage is below the high watermark"
+	case x.Kind == "volume" && policy.Volumes:
+		x.Detail = "not a dangling volume matching the volume label filters"
+	default:
+		x.Detail = x.Kind + "s are not collected"
+	}
+}
+
+// common checks the exclude list, the dgc.keep label and the label filters, which
+// apply to every type of resource.
+func (e *explainer) common(names []string, labels map[string]string) []Check {
+	policy := e.host.policy
+	var checks []Check
+	if entry, excluded := policy.Excludes.match(names...); excluded {
+		checks = append(checks, Check{"exclude", true, "excluded by " + entry})
+	} else if len(policy.Excludes) > 0 {
+		checks = append(checks, Check{"exclude", false, "matches no exclude entry"})
+	}
+	if keep, err := strconv.ParseBool(labels[keepLabel]); err == nil && keep {
+		checks = append(checks, Check{"label", true, "protected by label " + keepLabel})
+	}
+	for _, selector := range policy.Labels {
+		if selector.matches(labels) {
+			checks = append(checks, Check{"label", false, "matches label filter " + selector.String()})
+		} else {
+			checks = append(checks, Check{"label", true, "doesn't match label filter " + selector.String()})
+		}
+	}
+	return checks
+}
+
+// ruleCheck checks which rule, if any, applies to the resource. The second result is
+// false if no rule matches.
+func ruleCheck(rule *Rule, err error) (Check, bool) {
+	switch {
+	case err != nil:
+		return Check{"rule", true, err.Error()}, true
+	case rule == nil:
+		return Check{}, false
+	}
+	return Check{"rule", rule.keeps(), "matches " + rule.String()}, true
+}
+
+// grace checks how long the resource has gone unused against its grace period, noting
+// what set the grace period if it isn't the policy's.
+func (e *explainer) grace(rule *Rule, labels map[string]string, age time.Duration) Check {
+	grace := e.host.policy.grace(labels)
+	source := ""
+	if grace != e.host.policy.Grace {
+		source = ", set by label " + graceLabel
+	}
+	if rule != nil && rule.Action != ActionKeep {
+		grace = rule.graceOf(grace)
+		source = ", set by " + rule.Name
+	}
+	detail := fmt.Sprintf("unused for %s, grace period %s%s", units.HumanDuration(age), grace, source)
+	return Check{"grace", age < grace, detail}
+}
+
+// users returns the names of the containers for which used is true, and whether the
+// dry run would delete all of them.
+func (e *explainer) users(used func(dockerTypes.Container) bool) ([]string, bool) {
+	var names []string
+	removed := true
+	for _, container := range e.containers {
+		if !used(container) {
+			continue
+		}
+		name := container.ID
+		if len(container.Names) > 0 {
+			name = container.Names[0]
+		}
+		names = append(names, name)
+		removed = removed && e.removed[container.ID]
+	}
+	return names, removed
+}
+
+// inUse checks whether any container uses the resource. what says how, e.g. "used by".
+func (e *explainer) inUse(what string, used func(dockerTypes.Container) bool) (Check, bool) {
+	names, removed := e.users(used)
+	switch {
+	case len(names) == 0:
+		return Check{}, false
+	case removed:
+		return Check{"in_use", false, "only " + what + " containers that would be deleted first: " + strings.Join(names, ", ")}, true
+	}
+	return Check{"in_use", true, what + " " + strings.Join(names, ", ")}, true
+}
+
+func (e *explainer) container(container dockerTypes.Container) Explanation {
+	host := e.host
+	x := Explanation{Socket: host.socket, Kind: "container", ID: container.ID, Names: container.Names}
+	subject := containerSubject(container)
+	x.Checks = e.common(subject.names, container.Labels)
+	if host.policy.States[container.State] {
+		x.Checks = append(x.Checks, Check{"state", false, "state " + container.State + " is collected"})
+	} else {
+		x.Checks = append(x.Checks, Check{"state", true, "state " + container.State + " is not collected"})
+	}
+
+	now := time.Now()
+	unused := host.state.since(host.socket, "container", container.ID, time.Time{})
+	age := func() (time.Duration, error) {
+		finished, err := containerFinished(host, container)
+		if err != nil {
+			return 0, err
+		}
+		return now.Sub(later(finished, unused)), nil
+	}
+	rule, err := host.policy.matchRule("container", subject, age)
+	if check, ok := ruleCheck(rule, err); ok {
+		x.Checks = append(x.Checks, check)
+	}
+	if unusedFor, err := age(); err != nil {
+		x.Checks = append(x.Checks, Check{"grace", true, "failed to inspect: " + err.Error()})
+	} else {
+		x.Checks = append(x.Checks, e.grace(rule, container.Labels, unusedFor))
+	}
+	return x
+}
+
+func (e *explainer) image(image dockerTypes.ImageSummary) Explanation {
+	host := e.host
+	x := Explanation{Socket: host.socket, Kind: "image", ID: image.ID, Names: image.RepoTags}
+	subject := imageSubject(image)
+	x.Checks = e.common(subject.names, image.Labels)
+	if check, ok := e.inUse("used by", func(container dockerTypes.Container) bool {
+		return container.ImageID == image.ID
+	}); ok {
+		x.Checks = append(x.Checks, check)
+	}
+
+	// Docker refuses to delete an image other images are built on, so its children
+	// have to go in the same pass
+	var children []string
+	kept := false
+	for _, child := range e.images {
+		if child.ParentID != image.ID {
+			continue
+		}
+		name := child.ID
+		if len(child.RepoTags) > 0 {
+			name = child.RepoTags[0]
+		}
+		children = append(children, name)
+		kept = kept || e.decided["image/"+child.ID].Decision != DecisionPlanned
+	}
+	if len(children) > 0 {
+		x.Checks = append(x.Checks, Check{"children", kept, "has child images " + strings.Join(children, ", ")})
+	}
+
+	used := later(time.Unix(image.Created, 0), host.usage.get(host.socket, image.ID))
+	age := time.Now().Sub(host.state.since(host.socket, "image", image.ID, used))
+	rule, err := host.policy.matchRule("image", subject, func() (time.Duration, error) {
+		return age, nil
+	})
+	if check, ok := ruleCheck(rule, err); ok {
+		x.Checks = append(x.Checks, check)
+	}
+	retained, released := retainImages(e.images, host.policy.Retention)
+	if reason := retained[image.ID]; reason != "" {
+		x.Checks = append(x.Checks, Check{"retention", rule == nil, reason})
+	} else if reason := released[image.ID]; reason != "" {
+		x.Checks = append(x.Checks, Check{"retention", false, reason})
+	}
+	x.Checks = append(x.Checks, e.grace(rule, image.Labels, age))
+	return x
+}
+
+func (e *explainer) volume(volume *dockerTypes.Volume) Explanation {
+	host := e.host
+	x := Explanation{Socket: host.socket, Kind: "volume", ID: volume.Name}
+	subject := volumeSubject(volume)
+	x.Checks = e.common(subject.names, volume.Labels)
+	if check, ok := e.inUse("mounted by", func(container dockerTypes.Container) bool {
+		for _, mount := range container.Mounts {
+			if mount.Name == volume.Name {
+				return true
+			}
+		}
+		return false
+	}); ok {
+		x.Checks = append(x.Checks, check)
+	}
+
+	created, ok := volumeCreated(host, volume.Name)
+	known := ok || host.state != nil
+	age := time.Now().Sub(host.state.since(host.socket, "volume", volume.Name, created))
+	rule, err := host.policy.matchRule("volume", subject, func() (time.Duration, error) {
+		if !known {
+			return 0, errUnknownAge
+		}
+		return age, nil
+	})
+	if check, ok := ruleCheck(rule, err); ok {
+		x.Checks = append(x.Checks, check)
+	}
+	if known {
+		x.Checks = append(x.Checks, e.grace(rule, volume.Labels, age))
+	} else {
+		x.Checks = append(x.Checks, Check{"grace", true, errUnknownAge.Error()})
+	}
+	return x
+}
+
+func (e *explainer) network(network dockerTypes.NetworkResource) Explanation {
+	host := e.host
+	x := Explanation{Socket: host.socket, Kind: "network", ID: network.ID, Names: []string{network.Name}}
+	if builtinNetworks[network.Name] || network.Ingress {
+		x.Checks = append(x.Checks, Check{"builtin", true, "created by the docker daemon"})
+	}
+	subject := networkSubject(network)
+	x.Checks = append(x.Checks, e.common(subject.names, network.Labels)...)
+
+	inspected, err := host.client.NetworkInspect(context.Background(), network.ID, false)
+	if err != nil {
+		x.Checks = append(x.Checks, Check{"in_use", true, "failed to inspect: " + err.Error()})
+	} else if check, ok := e.inUse("attached to", func(container dockerTypes.Container) bool {
+		_, attached := inspected.Containers[container.ID]
+		return attached
+	}); ok {
+		x.Checks = append(x.Checks, check)
+	}
+
+	age := time.Now().Sub(host.state.since(host.socket, "network", network.ID, network.Created))
+	rule, err := host.policy.matchRule("network", subject, func() (time.Duration, error) {
+		return age, nil
+	})
+	if check, ok := ruleCheck(rule, err); ok {
+		x.Checks = append(x.Checks, check)
+	}
+	x.Checks = append(x.Checks, e.grace(rule, network.Labels, age))
+	return x
+}
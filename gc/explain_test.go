@@ -0,0 +1,92 @@
+package gc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hatchery/dgc/gc/gctest"
+)
+
+// checkNames returns the names of the checks, marking those that keep the resource.
+func checkNames(x Explanation) []string {
+	var names []string
+	for _, check := range x.Checks {
+		if check.Keeps {
+			names = append(names, check.Name+"!")
+		} else {
+			names = append(names, check.Name)
+		}
+	}
+	return names
+}
+
+func TestExplain(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "c0ffee", Names: []string{"/old"}, State: "exited", ImageID: "sha256:app",
+		Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour)})
+	server.AddContainer(gctest.Container{ID: "beef", Names: []string{"/kept"}, State: "exited", ImageID: "sha256:base",
+		Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour), Labels: map[string]string{"dgc.keep": "true"}})
+	server.AddImage(gctest.Image{ID: "sha256:app", ParentID: "sha256:base", RepoTags: []string{"app:latest"}, Created: ago(48 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:base", RepoTags: []string{"base:1"}, Created: ago(48 * time.Hour)})
+
+	policy := &Policy{Grace: time.Hour, States: ParseStates(DefaultStates)}
+	collector := newTestCollector(t, server, policy)
+
+	// The image is only used by a container that goes first
+	explained, err := collector.Explain("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explained) != 1 || explained[0].ID != "sha256:app" {
+		t.Fatalf("got %+v, want the image app:latest", explained)
+	}
+	x := explained[0]
+	if got, want := checkNames(x), []string{"in_use", "grace"}; !equalStrings(got, want) {
+		t.Errorf("got checks %v, want %v", got, want)
+	}
+	if x.Decision != DecisionPlanned || x.Reason != "grace" {
+		t.Errorf("got decision %s %s, want planned grace", x.Decision, x.Reason)
+	}
+
+	// The parent is used by a container that is kept, and has a child image
+	explained, err = collector.Explain("base:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x = explained[0]
+	if got, want := checkNames(x), []string{"in_use!", "children", "grace"}; !equalStrings(got, want) {
+		t.Errorf("got checks %v, want %v", got, want)
+	}
+	if x.Decision != DecisionKept || x.Reason != "in_use" {
+		t.Errorf("got decision %s %s, want kept in_use", x.Decision, x.Reason)
+	}
+
+	// Names come before IDs, so beef is the container and not an ID prefix
+	explained, err = collector.Explain("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explained) != 1 || explained[0].ID != "beef" {
+		t.Fatalf("got %+v, want the container kept", explained)
+	}
+	if got, want := checkNames(explained[0]), []string{"label!", "state", "grace"}; !equalStrings(got, want) {
+		t.Errorf("got checks %v, want %v", got, want)
+	}
+	explained, err = collector.Explain("c0f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explained) != 1 || explained[0].ID != "c0ffee" {
+		t.Errorf("got %+v, want the container old by ID prefix", explained)
+	}
+
+	if explained, err = collector.Explain("missing"); err != nil || len(explained) != 0 {
+		t.Errorf("got %v and %v, want nothing", explained, err)
+	}
+	for _, request := range server.Requests() {
+		if request[:7] == "DELETE " {
+			t.Errorf("explain made request %s", request)
+		}
+	}
+}
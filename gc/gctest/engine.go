@@ -0,0 +1,791 @@
+package gctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	containerTypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+)
+
+// Container is a container in the fake daemon.
+type Container struct {
+	ID string
+	// Names are the names of the container, each starting with a slash like "/web".
+	Names   []string
+	Image   string
+	ImageID string
+	// State is one of created, running, paused, restarting, exited or dead.
+	State   string
+	Created time.Time
+	// Finished is when the container last stopped, or zero if it never ran.
+	Finished time.Time
+	Labels   map[string]string
+	SizeRw   int64
+	// Volumes are the names of the volumes the container mounts.
+	Volumes []string
+	// Networks are the names of the networks the container is attached to.
+	Networks []string
+}
+
+// Image is an image in the fake daemon.
+type Image struct {
+	ID       string
+	ParentID string
+	RepoTags []string
+	Created  time.Time
+	Labels   map[string]string
+	// Size is the size of the image including its parents, of which SharedSize bytes
+	// are shared with other images.
+	Size       int64
+	SharedSize int64
+}
+
+// Volume is a volume in the fake daemon.
+type Volume struct {
+	Name   string
+	Labels map[string]string
+	// Created is when the volume was created, or zero to leave it out like daemons
+	// older than API 1.29 do.
+	Created time.Time
+	Size    int64
+}
+
+// Network is a network in the fake daemon. Containers attach to it by name.
+type Network struct {
+	ID      string
+	Name    string
+	Created time.Time
+	Labels  map[string]string
+}
+
+// AddContainer adds a container to the daemon.
+func (s *Server) AddContainer(c Container) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.containers = append(s.containers, &c)
+}
+
+// AddImage adds an image to the daemon.
+func (s *Server) AddImage(i Image) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.images = append(s.images, &i)
+}
+
+// AddVolume adds a volume to the daemon.
+func (s *Server) AddVolume(v Volume) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.volumes = append(s.volumes, &v)
+}
+
+// AddNetwork adds a network to the daemon.
+func (s *Server) AddNetwork(n Network) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.networks = append(s.networks, &n)
+}
+
+// Containers returns the containers that are left, in the order they were added.
+func (s *Server) Containers() []Container {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var containers []Container
+	for _, c := range s.containers {
+		containers = append(containers, *c)
+	}
+	return containers
+}
+
+// Images returns the images that are left, in the order they were added.
+func (s *Server) Images() []Image {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var images []Image
+	for _, i := range s.images {
+		images = append(images, *i)
+	}
+	return images
+}
+
+// Volumes returns the volumes that are left, in the order they were added.
+func (s *Server) Volumes() []Volume {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var volumes []Volume
+	for _, v := range s.volumes {
+		volumes = append(volumes, *v)
+	}
+	return volumes
+}
+
+// Networks returns the networks that are left, in the order they were added.
+func (s *Server) Networks() []Network {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var networks []Network
+	for _, n := range s.networks {
+		networks = append(networks, *n)
+	}
+	return networks
+}
+
+// SetState moves a container to another state, as if it was started or stopped, and
+// emits the event docker would.
+func (s *Server) SetState(id, state string) {
+	s.lock.Lock()
+	c := s.container(id)
+	if c == nil {
+		s.lock.Unlock()
+		return
+	}
+	action := ""
+	switch {
+	case state == "running" && c.State != "running":
+		action = "start"
+	case c.State == "running" && state != "running":
+		action = "die"
+		c.Finished = time.Now()
+	}
+	c.State = state
+	image := c.Image
+	s.lock.Unlock()
+
+	if action != "" {
+		s.Emit(containerEvent(action, id, image))
+	}
+}
+
+// containerEvent returns the event docker emits about a container.
+func containerEvent(action, id, image string) events.Message {
+	return events.Message{
+		Status: action,
+		ID:     id,
+		From:   image,
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: id, Attributes: map[string]string{"image": image}},
+	}
+}
+
+// boolValue reads a boolean query parameter the way the docker daemon does.
+func boolValue(r *http.Request, name string) bool {
+	switch strings.ToLower(strings.TrimSpace(r.Form.Get(name))) {
+	case "", "0", "no", "false", "none":
+		return false
+	}
+	return true
+}
+
+// matchBool reports whether a boolean filter, such as dangling=true, matches value.
+func matchBool(args filters.Args, field string, value bool) bool {
+	if !args.Include(field) {
+		return true
+	}
+	for _, v := range args.Get(field) {
+		if b, err := strconv.ParseBool(v); err == nil && b == value {
+			return true
+		}
+	}
+	return false
+}
+
+// filterArgs reads the filters query parameter.
+func filterArgs(w http.ResponseWriter, r *http.Request) (filters.Args, bool) {
+	args, err := filters.FromParam(r.Form.Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return args, false
+	}
+	return args, true
+}
+
+// container finds a container by ID, name or unique ID prefix. The lock must be held.
+func (s *Server) container(ref string) *Container {
+	var found *Container
+	for _, c := range s.containers {
+		if c.ID == ref {
+			return c
+		}
+		for _, name := range c.Names {
+			if strings.TrimPrefix(name, "/") == strings.TrimPrefix(ref, "/") {
+				return c
+			}
+		}
+		if strings.HasPrefix(c.ID, ref) {
+			if found != nil {
+				return nil
+			}
+			found = c
+		}
+	}
+	return found
+}
+
+// apiContainer returns the container as listed by the Engine API.
+func apiContainer(c *Container, size bool) dockerTypes.Container {
+	container := dockerTypes.Container{
+		ID:      c.ID,
+		Names:   c.Names,
+		Image:   c.Image,
+		ImageID: c.ImageID,
+		Created: c.Created.Unix(),
+		Labels:  c.Labels,
+		State:   c.State,
+		Status:  c.State,
+	}
+	if size {
+		container.SizeRw = c.SizeRw
+	}
+	for _, name := range c.Volumes {
+		container.Mounts = append(container.Mounts, dockerTypes.MountPoint{Type: "volume", Name: name, Driver: "local"})
+	}
+	return container
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	args, ok := filterArgs(w, r)
+	if !ok {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	containers := []dockerTypes.Container{}
+	for _, c := range s.containers {
+		if !boolValue(r, "all") && c.State != "running" {
+			continue
+		}
+		if !args.FuzzyMatch("id", c.ID) || !args.ExactMatch("status", c.State) || !args.MatchKVList("label", c.Labels) {
+			continue
+		}
+		containers = append(containers, apiContainer(c, boolValue(r, "size")))
+	}
+	writeJSON(w, http.StatusOK, containers)
+}
+
+func (s *Server) inspectContainer(w http.ResponseWriter, ref string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.container(ref)
+	if c == nil {
+		writeError(w, http.StatusNotFound, "No such container: "+ref)
+		return
+	}
+	name := ""
+	if len(c.Names) > 0 {
+		name = c.Names[0]
+	}
+	writeJSON(w, http.StatusOK, dockerTypes.ContainerJSON{
+		ContainerJSONBase: &dockerTypes.ContainerJSONBase{
+			ID:      c.ID,
+			Created: c.Created.UTC().Format(time.RFC3339Nano),
+			Name:    name,
+			Image:   c.ImageID,
+			State: &dockerTypes.ContainerState{
+				Status:     c.State,
+				Running:    c.State == "running" || c.State == "paused" || c.State == "restarting",
+				Paused:     c.State == "paused",
+				Restarting: c.State == "restarting",
+				Dead:       c.State == "dead",
+				FinishedAt: c.Finished.UTC().Format(time.RFC3339Nano),
+			},
+		},
+		Config: &containerTypes.Config{Image: c.Image, Labels: c.Labels},
+	})
+}
+
+func (s *Server) removeContainer(w http.ResponseWriter, r *http.Request, ref string) {
+	s.lock.Lock()
+	c := s.container(ref)
+	if c == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "No such container: "+ref)
+		return
+	}
+	if c.State == "running" && !boolValue(r, "force") {
+		s.lock.Unlock()
+		writeError(w, http.StatusConflict, fmt.Sprintf("You cannot remove a running container %s. "+
+			"Stop the container before attempting removal or use -f", c.ID))
+		return
+	}
+	for i := range s.containers {
+		if s.containers[i] == c {
+			s.containers = append(s.containers[:i], s.containers[i+1:]...)
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	s.Emit(containerEvent("destroy", c.ID, c.Image))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// image finds an image by ID, tag or unique ID prefix. The second result is the tag
+// the image was found by, if any. The lock must be held.
+func (s *Server) image(ref string) (*Image, string) {
+	tag := ref
+	if i := strings.LastIndex(ref, ":"); i < 0 || strings.Contains(ref[i:], "/") {
+		tag += ":latest"
+	}
+	var found *Image
+	for _, i := range s.images {
+		if i.ID == ref || i.ID == "sha256:"+ref {
+			return i, ""
+		}
+		for _, t := range i.RepoTags {
+			if t == tag {
+				return i, t
+			}
+		}
+		if strings.HasPrefix(strings.TrimPrefix(i.ID, "sha256:"), strings.TrimPrefix(ref, "sha256:")) {
+			if found != nil {
+				return nil, ""
+			}
+			found = i
+		}
+	}
+	return found, ""
+}
+
+// imageConflict returns why the image can't be deleted, or an empty string if it can.
+// The lock must be held.
+func (s *Server) imageConflict(image *Image, force bool) string {
+	for _, c := range s.containers {
+		if c.ImageID != image.ID {
+			continue
+		}
+		if c.State == "running" {
+			return fmt.Sprintf("conflict: unable to delete %s (cannot be forced) - image is being used by running container %s", image.ID, c.ID)
+		}
+		if !force {
+			return fmt.Sprintf("conflict: unable to delete %s (must be forced) - image is being used by stopped container %s", image.ID, c.ID)
+		}
+	}
+	for _, i := range s.images {
+		if i.ParentID == image.ID {
+			return fmt.Sprintf("conflict: unable to delete %s (cannot be forced) - image has dependent child images", image.ID)
+		}
+	}
+	return ""
+}
+
+// deleteImage removes an image from the model. The lock must be held.
+func (s *Server) deleteImage(image *Image) {
+	for i := range s.images {
+		if s.images[i] == image {
+			s.images = append(s.images[:i], s.images[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Server) removeImage(w http.ResponseWriter, r *http.Request, ref string) {
+	s.lock.Lock()
+	image, tag := s.image(ref)
+	if image == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	force := boolValue(r, "force")
+
+	// Removing one of several tags only untags the image
+	if tag != "" && len(image.RepoTags) > 1 {
+		for i, t := range image.RepoTags {
+			if t == tag {
+				image.RepoTags = append(image.RepoTags[:i:i], image.RepoTags[i+1:]...)
+				break
+			}
+		}
+		s.lock.Unlock()
+		s.Emit(events.Message{Type: events.ImageEventType, Action: "untag", Actor: events.Actor{ID: image.ID}})
+		writeJSON(w, http.StatusOK, []dockerTypes.ImageDeleteResponseItem{{Untagged: tag}})
+		return
+	}
+	if tag == "" && len(image.RepoTags) > 1 && !force {
+		s.lock.Unlock()
+		writeError(w, http.StatusConflict, fmt.Sprintf("conflict: unable to delete %s (must be forced) - "+
+			"image is referenced in multiple repositories", image.ID))
+		return
+	}
+	if conflict := s.imageConflict(image, force); conflict != "" {
+		s.lock.Unlock()
+		writeError(w, http.StatusConflict, conflict)
+		return
+	}
+
+	var items []dockerTypes.ImageDeleteResponseItem
+	var deleted []string
+	for _, t := range image.RepoTags {
+		items = append(items, dockerTypes.ImageDeleteResponseItem{Untagged: t})
+	}
+	s.deleteImage(image)
+	items = append(items, dockerTypes.ImageDeleteResponseItem{Deleted: image.ID})
+	deleted = append(deleted, image.ID)
+
+	// Prune the untagged parents that nothing else depends on
+	for parentID := image.ParentID; parentID != "" && !boolValue(r, "noprune"); {
+		parent, _ := s.image(parentID)
+		if parent == nil || len(parent.RepoTags) > 0 || s.imageConflict(parent, false) != "" {
+			break
+		}
+		s.deleteImage(parent)
+		items = append(items, dockerTypes.ImageDeleteResponseItem{Deleted: parent.ID})
+		deleted = append(deleted, parent.ID)
+		parentID = parent.ParentID
+	}
+	s.lock.Unlock()
+
+	for _, id := range deleted {
+		s.Emit(events.Message{Type: events.ImageEventType, Action: "delete", Actor: events.Actor{ID: id}})
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	images := []dockerTypes.ImageSummary{}
+	for _, i := range s.images {
+		images = append(images, apiImage(i))
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+// apiImage returns the image as listed by the Engine API.
+func apiImage(i *Image) dockerTypes.ImageSummary {
+	tags := i.RepoTags
+	if len(tags) == 0 {
+		tags = []string{"<none>:<none>"}
+	}
+	return dockerTypes.ImageSummary{
+		ID:          i.ID,
+		ParentID:    i.ParentID,
+		RepoTags:    tags,
+		RepoDigests: []string{},
+		Created:     i.Created.Unix(),
+		Labels:      i.Labels,
+		Size:        i.Size,
+		SharedSize:  i.SharedSize,
+		VirtualSize: i.Size,
+		Containers:  -1,
+	}
+}
+
+// volume finds a volume by name. The lock must be held.
+func (s *Server) volume(name string) *Volume {
+	for _, v := range s.volumes {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// mountedBy returns the IDs of the containers that mount a volume. The lock must be held.
+func (s *Server) mountedBy(name string) []string {
+	var ids []string
+	for _, c := range s.containers {
+		for _, v := range c.Volumes {
+			if v == name {
+				ids = append(ids, c.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// apiVolume returns the volume as listed by the Engine API.
+func (s *Server) apiVolume(v *Volume) *dockerTypes.Volume {
+	return &dockerTypes.Volume{
+		Name:       v.Name,
+		Driver:     "local",
+		Labels:     v.Labels,
+		Mountpoint: "/var/lib/docker/volumes/" + v.Name + "/_data",
+		Options:    map[string]string{},
+		Scope:      "local",
+		UsageData:  &dockerTypes.VolumeUsageData{Size: v.Size, RefCount: int64(len(s.mountedBy(v.Name)))},
+	}
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	args, ok := filterArgs(w, r)
+	if !ok {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	body := volumeTypes.VolumesListOKBody{Volumes: []*dockerTypes.Volume{}, Warnings: []string{}}
+	for _, v := range s.volumes {
+		if !matchBool(args, "dangling", len(s.mountedBy(v.Name)) == 0) || !args.MatchKVList("label", v.Labels) {
+			continue
+		}
+		body.Volumes = append(body.Volumes, s.apiVolume(v))
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) inspectVolume(w http.ResponseWriter, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v := s.volume(name)
+	if v == nil {
+		writeError(w, http.StatusNotFound, "get "+name+": no such volume")
+		return
+	}
+	created := ""
+	if !v.Created.IsZero() {
+		created = v.Created.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		*dockerTypes.Volume
+		CreatedAt string `json:",omitempty"`
+	}{s.apiVolume(v), created})
+}
+
+func (s *Server) removeVolume(w http.ResponseWriter, r *http.Request, name string) {
+	s.lock.Lock()
+	v := s.volume(name)
+	if v == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "get "+name+": no such volume")
+		return
+	}
+	if ids := s.mountedBy(name); len(ids) > 0 {
+		s.lock.Unlock()
+		writeError(w, http.StatusConflict, fmt.Sprintf("remove %s: volume is in use - [%s]", name, strings.Join(ids, ", ")))
+		return
+	}
+	for i := range s.volumes {
+		if s.volumes[i] == v {
+			s.volumes = append(s.volumes[:i], s.volumes[i+1:]...)
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	s.Emit(events.Message{Type: events.VolumeEventType, Action: "destroy", Actor: events.Actor{ID: name}})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// network finds a network by ID, name or unique ID prefix. The lock must be held.
+func (s *Server) network(ref string) *Network {
+	var found *Network
+	for _, n := range s.networks {
+		if n.ID == ref || n.Name == ref {
+			return n
+		}
+		if strings.HasPrefix(n.ID, ref) {
+			if found != nil {
+				return nil
+			}
+			found = n
+		}
+	}
+	return found
+}
+
+// attached returns the endpoints of the containers attached to a network. The lock
+// must be held.
+func (s *Server) attached(n *Network) map[string]dockerTypes.EndpointResource {
+	endpoints := make(map[string]dockerTypes.EndpointResource)
+	for _, c := range s.containers {
+		for _, name := range c.Networks {
+			if name == n.Name || name == n.ID {
+				endpoints[c.ID] = dockerTypes.EndpointResource{Name: strings.TrimPrefix(strings.Join(c.Names, ""), "/")}
+			}
+		}
+	}
+	return endpoints
+}
+
+// apiNetwork returns the network as the Engine API describes it, with its endpoints
+// only when inspected.
+func (s *Server) apiNetwork(n *Network, inspect bool) dockerTypes.NetworkResource {
+	network := dockerTypes.NetworkResource{
+		Name:       n.Name,
+		ID:         n.ID,
+		Created:    n.Created,
+		Scope:      "local",
+		Driver:     "bridge",
+		Labels:     n.Labels,
+		Options:    map[string]string{},
+		Containers: map[string]dockerTypes.EndpointResource{},
+	}
+	if inspect {
+		network.Containers = s.attached(n)
+	}
+	return network
+}
+
+func (s *Server) listNetworks(w http.ResponseWriter, r *http.Request) {
+	args, ok := filterArgs(w, r)
+	if !ok {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	networks := []dockerTypes.NetworkResource{}
+	for _, n := range s.networks {
+		if args.MatchKVList("label", n.Labels) {
+			networks = append(networks, s.apiNetwork(n, false))
+		}
+	}
+	writeJSON(w, http.StatusOK, networks)
+}
+
+func (s *Server) inspectNetwork(w http.ResponseWriter, ref string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := s.network(ref)
+	if n == nil {
+		writeError(w, http.StatusNotFound, "network "+ref+" not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.apiNetwork(n, true))
+}
+
+func (s *Server) removeNetwork(w http.ResponseWriter, ref string) {
+	s.lock.Lock()
+	n := s.network(ref)
+	if n == nil {
+		s.lock.Unlock()
+		writeError(w, http.StatusNotFound, "network "+ref+" not found")
+		return
+	}
+	if n.Name == "bridge" || n.Name == "host" || n.Name == "none" {
+		s.lock.Unlock()
+		writeError(w, http.StatusForbidden, n.Name+" is a pre-defined network and cannot be removed")
+		return
+	}
+	if len(s.attached(n)) > 0 {
+		s.lock.Unlock()
+		writeError(w, http.StatusForbidden, fmt.Sprintf("error while removing network: network %s id %s has active endpoints", n.Name, n.ID))
+		return
+	}
+	for i := range s.networks {
+		if s.networks[i] == n {
+			s.networks = append(s.networks[:i], s.networks[i+1:]...)
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	s.Emit(events.Message{Type: events.NetworkEventType, Action: "destroy", Actor: events.Actor{ID: n.ID}})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// diskUsage reports the space used by the model. Layers shared between images are
+// counted once, as the largest shared size of any image.
+func (s *Server) diskUsage(w http.ResponseWriter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	du := dockerTypes.DiskUsage{
+		Images:     []*dockerTypes.ImageSummary{},
+		Containers: []*dockerTypes.Container{},
+		Volumes:    []*dockerTypes.Volume{},
+	}
+	var shared int64
+	for _, i := range s.images {
+		image := apiImage(i)
+		du.Images = append(du.Images, &image)
+		du.LayersSize += i.Size - i.SharedSize
+		if i.SharedSize > shared {
+			shared = i.SharedSize
+		}
+	}
+	du.LayersSize += shared
+	for _, c := range s.containers {
+		container := apiContainer(c, true)
+		du.Containers = append(du.Containers, &container)
+	}
+	for _, v := range s.volumes {
+		du.Volumes = append(du.Volumes, s.apiVolume(v))
+	}
+	writeJSON(w, http.StatusOK, du)
+}
+
+// streamEvents follows the events stream, starting with the past events since the
+// requested time, until the client goes away or the server is closed.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	args, ok := filterArgs(w, r)
+	if !ok {
+		return
+	}
+	matches := func(m events.Message) bool {
+		return args.ExactMatch("type", m.Type) && args.ExactMatch("event", m.Action)
+	}
+	var since int64
+	if value := r.Form.Get("since"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since: "+value)
+			return
+		}
+		since = int64(seconds * float64(time.Second))
+	}
+
+	ch, done := make(chan events.Message), make(chan struct{})
+	s.lock.Lock()
+	var past []events.Message
+	for _, m := range s.history {
+		if since != 0 && m.TimeNano >= since && matches(m) {
+			past = append(past, m)
+		}
+	}
+	s.subscribers[ch] = done
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.subscribers, ch)
+		s.lock.Unlock()
+		close(done)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	send := func(m events.Message) bool {
+		if err := writeEvent(w, m); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for _, m := range past {
+		if !send(m) {
+			return
+		}
+	}
+	for {
+		select {
+		case m := <-ch:
+			if matches(m) && !send(m) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// writeEvent writes a message to the events stream.
+func writeEvent(w http.ResponseWriter, m events.Message) error {
+	return json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,225 @@
+// Package gctest provides a fake docker Engine API for testing garbage collection
+// without a docker daemon. A Server answers the requests the collector makes from an
+// in-memory model of containers, images, volumes and networks, and can be scripted to
+// fail or slow down chosen requests.
+package gctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	dockerClient "github.com/docker/docker/client"
+)
+
+// APIVersion is the Engine API version the server speaks and Client asks for.
+const APIVersion = "1.29"
+
+// versionPrefix matches the API version clients put in front of every path.
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// Fault scripts the response to the requests that match Method and Path.
+type Fault struct {
+	// Method is the HTTP method to match, or empty to match any.
+	Method string
+	// Path is a path.Match pattern for the path without its version prefix, such as
+	// "/containers/*" or "/images/*".
+	Path string
+	// Delay is how long to wait before answering.
+	Delay time.Duration
+	// Status, if set, is returned along with Message instead of the normal response.
+	Status  int
+	Message string
+	// Times is how many requests the fault applies to, or 0 for every request.
+	Times int
+}
+
+// matches reports whether the fault applies to a request.
+func (f *Fault) matches(method, p string) bool {
+	if f.Method != "" && f.Method != method {
+		return false
+	}
+	ok, _ := path.Match(f.Path, p)
+	return ok
+}
+
+// Server is a fake docker daemon serving the Engine API over HTTP.
+type Server struct {
+	// URL is the address of the server, as a tcp:// docker host.
+	URL    string
+	server *httptest.Server
+	closed chan struct{}
+
+	lock       sync.Mutex
+	containers []*Container
+	images     []*Image
+	volumes    []*Volume
+	networks   []*Network
+	faults     []*Fault
+	requests   []string
+	history    []events.Message
+	// subscribers receive the events emitted while they follow the events stream,
+	// until the channel they are mapped to is closed.
+	subscribers map[chan events.Message]chan struct{}
+}
+
+// NewServer starts a fake docker daemon with nothing in it. It must be closed once
+// the test is over.
+func NewServer() *Server {
+	s := &Server{
+		closed:      make(chan struct{}),
+		subscribers: make(map[chan events.Message]chan struct{}),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = "tcp://" + strings.TrimPrefix(s.server.URL, "http://")
+	return s
+}
+
+// Close ends every events stream and shuts the server down.
+func (s *Server) Close() {
+	close(s.closed)
+	s.server.Close()
+}
+
+// Client returns a docker client connected to the server.
+func (s *Server) Client() *dockerClient.Client {
+	client, err := dockerClient.NewClient(s.URL, APIVersion, nil, nil)
+	if err != nil {
+		panic("gctest: failed to create a docker client: " + err.Error())
+	}
+	return client
+}
+
+// Script adds a fault. Faults are tried in the order they were added, and only the
+// first that matches a request applies to it.
+func (s *Server) Script(f Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// Requests returns every request served so far, as the method and the path without its
+// version prefix, such as "DELETE /containers/abc".
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Emit sends a message to every client following the events stream, and remembers it
+// for those that ask for past events.
+func (s *Server) Emit(message events.Message) {
+	s.lock.Lock()
+	if message.TimeNano == 0 {
+		now := time.Now()
+		message.Time, message.TimeNano = now.Unix(), now.UnixNano()
+	}
+	s.history = append(s.history, message)
+	subscribers := make(map[chan events.Message]chan struct{})
+	for ch, done := range s.subscribers {
+		subscribers[ch] = done
+	}
+	s.lock.Unlock()
+
+	for ch, done := range subscribers {
+		select {
+		case ch <- message:
+		case <-done:
+		case <-s.closed:
+		}
+	}
+}
+
+// fault returns the fault that applies to a request, if any, using it up.
+func (s *Server) fault(method, p string) *Fault {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, method+" "+p)
+	for i, f := range s.faults {
+		if !f.matches(method, p) {
+			continue
+		}
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		applied := *f
+		return &applied
+	}
+	return nil
+}
+
+// writeJSON writes value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error the way the docker daemon does.
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, dockerTypes.ErrorResponse{Message: message})
+}
+
+// serveHTTP applies any scripted fault to the request, then routes it.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	if f := s.fault(r.Method, p); f != nil {
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return
+		}
+		if f.Status != 0 {
+			writeError(w, f.Status, f.Message)
+			return
+		}
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && p == "/_ping":
+		w.Write([]byte("OK"))
+	case r.Method == http.MethodGet && p == "/containers/json":
+		s.listContainers(w, r)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "containers" && parts[2] == "json":
+		s.inspectContainer(w, parts[1])
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "containers":
+		s.removeContainer(w, r, parts[1])
+	case r.Method == http.MethodGet && p == "/images/json":
+		s.listImages(w, r)
+	case r.Method == http.MethodDelete && len(parts) >= 2 && parts[0] == "images":
+		// Tags may contain slashes
+		s.removeImage(w, r, strings.Join(parts[1:], "/"))
+	case r.Method == http.MethodGet && p == "/volumes":
+		s.listVolumes(w, r)
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "volumes":
+		s.inspectVolume(w, parts[1])
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "volumes":
+		s.removeVolume(w, r, parts[1])
+	case r.Method == http.MethodGet && p == "/networks":
+		s.listNetworks(w, r)
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "networks":
+		s.inspectNetwork(w, parts[1])
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "networks":
+		s.removeNetwork(w, parts[1])
+	case r.Method == http.MethodGet && p == "/system/df":
+		s.diskUsage(w)
+	case r.Method == http.MethodGet && p == "/events":
+		s.streamEvents(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "page not found")
+	}
+}
@@ -0,0 +1,201 @@
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// referencedImages returns the IDs of the images used by the given containers,
+// leaving out any container that is about to be removed.
+func referencedImages(containers []dockerTypes.Container, removed map[string]bool) map[string]bool {
+	referenced := make(map[string]bool)
+	for _, container := range containers {
+		if !removed[container.ID] {
+			referenced[container.ImageID] = true
+		}
+	}
+	return referenced
+}
+
+// imageSubject returns what rules are matched against for the image.
+func imageSubject(image dockerTypes.ImageSummary) subject {
+	return subject{names: append([]string{image.ID}, image.RepoTags...), labels: image.Labels}
+}
+
+// collectAPIImages deletes the images that are outside the grace period and are not
+// used by any container. Images are deleted in waves, children before their parents,
+// because docker refuses to delete an image that other images are built on.
+// sizes holds the exclusive size of the images by ID, where known.
+func collectAPIImages(images []dockerTypes.ImageSummary, referenced map[string]bool, sizes map[string]int64, host *dockerHost) TypeResult {
+	result := newTypeResult(host, "image")
+	dryRun := host.dryRun
+	// Parents are deleted in later waves if the policy collects them, so docker must not
+	// prune them along with their children
+	options := dockerTypes.ImageRemoveOptions{
+		Force:         host.policy.Force,
+		PruneChildren: false,
+	}
+
+	children := make(map[string]int)
+	for _, image := range images {
+		if image.ParentID != "" {
+			children[image.ParentID]++
+		}
+	}
+
+	kept, _ := retainImages(images, host.policy.Retention)
+
+	var pending []Candidate
+	parents := make(map[string]string)
+	host.state.check(host.socket, "image")
+	for _, image := range images {
+		result.Examined++
+
+		// Layers shared with other images are not freed by deleting this one
+		size, ok := sizes[image.ID]
+		if !ok {
+			size = exclusiveSize(image.Size, image.SharedSize)
+		}
+		c := Candidate{Kind: "image", ID: image.ID, Names: image.RepoTags, Size: size}
+
+		// Check if the image id or tag is on excludes list
+		if entry, excluded := host.policy.Excludes.match(append([]string{image.ID}, image.RepoTags...)...); excluded {
+			result.keep(c, "excluded", "excluded by "+entry)
+			continue
+		}
+
+		// Skip the image if its labels protect it or don't match the label filters
+		if reason := host.policy.keptByLabels(image.Labels); reason != "" {
+			result.keep(c, "label", reason)
+			continue
+		}
+
+		// Skip the image if a container still uses it
+		if referenced[image.ID] {
+			result.keep(c, "in_use", "used by a container")
+			continue
+		}
+
+		host.log.Printf("Inspecting image: %s\n", image.ID)
+
+		// The image is as old as the last time a container used it, or since it was
+		// first seen unused
+		now := time.Now()
+		used := later(time.Unix(image.Created, 0), host.usage.get(host.socket, image.ID))
+		used = host.state.since(host.socket, "image", image.ID, used)
+		age := now.Sub(used)
+		c.Age = age
+
+		rule, _ := host.policy.matchRule("image", imageSubject(image), func() (time.Duration, error) {
+			return age, nil
+		})
+		if rule.keeps() {
+			result.keep(c, "rule", ruleKept(rule))
+			continue
+		}
+
+		// Keep the newest images of repositories with a retention rule, unless a rule
+		// already decided otherwise
+		if reason := kept[image.ID]; reason != "" && rule == nil {
+			result.keep(c, "retention", reason)
+			continue
+		}
+
+		// Skip the image if it is still in the grace period
+		grace := rule.graceOf(host.policy.grace(image.Labels))
+		if age < grace {
+			result.keep(c, "grace", rule.graceKept(grace))
+			continue
+		}
+		c.Reason, c.Rule = rule.selected(grace)
+
+		parents[image.ID] = image.ParentID
+		pending = append(pending, c)
+	}
+
+	for len(pending) > 0 {
+		// Every pending image without children can be deleted in this wave
+		var wave, blocked []Candidate
+		for _, c := range pending {
+			if children[c.ID] == 0 {
+				wave = append(wave, c)
+			} else {
+				blocked = append(blocked, c)
+			}
+		}
+		if len(wave) == 0 {
+			for _, c := range blocked {
+				host.log.Printf("Skipping image with child images: %s\n", c.ID)
+				result.keep(c, "children", "has child images")
+			}
+			break
+		}
+		pending = blocked
+
+		// Delete the least valuable images first, until enough disk space is reclaimed
+		sortByValue(wave)
+		if host.budget != nil {
+			for i, c := range wave {
+				if host.budget.exhausted() {
+					// Neither the rest of this wave nor the pending images are needed
+					for _, c := range wave[i:] {
+						result.keep(c, "watermark", watermarkKept)
+					}
+					for _, c := range pending {
+						result.keep(c, "watermark", watermarkKept)
+					}
+					wave, pending = wave[:i], nil
+					break
+				}
+				host.budget.spend(c.Size)
+			}
+		}
+
+		if dryRun {
+			for _, c := range wave {
+				result.plan(c)
+				children[parents[c.ID]]--
+			}
+			continue
+		}
+
+		var imageSync sync.WaitGroup
+		var resultLock sync.Mutex
+		for _, c := range wave {
+			imageSync.Add(1)
+			c := c
+			host.workers.Go(func() {
+				defer imageSync.Done()
+
+				// Delete image
+				host.log.Printf("Deleting image: %s\n", c.ID)
+
+				_, err := host.client.ImageRemove(context.Background(), c.ID, options)
+				// The image may already have been deleted by someone else
+				if dockerClient.IsErrImageNotFound(err) {
+					err = nil
+				}
+				resultLock.Lock()
+				defer resultLock.Unlock()
+				if err == nil {
+					result.deleted(c)
+					children[parents[c.ID]]--
+					host.log.Printf("Deleted image: %s (%s)\n", c.ID, units.HumanSize(float64(c.Size)))
+				} else {
+					result.failed(c, err)
+					host.budget.spend(-c.Size)
+					host.log.Printf("Error. Failed to delete image: %s: %s\n", c.ID, err)
+					return
+				}
+			})
+		}
+		imageSync.Wait()
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+package gc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// keepLabel protects a resource from collection when set to a true value.
+	keepLabel = "dgc.keep"
+	// graceLabel overrides the grace period of a resource, e.g. dgc.grace=72h.
+	graceLabel = "dgc.grace"
+)
+
+// LabelSelector matches resources by label. It is written as key, key=value,
+// !key or key!=value.
+type LabelSelector struct {
+	key      string
+	value    string
+	hasValue bool
+	negate   bool
+}
+
+// ParseLabelSelector parses a --label-filter value.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var s LabelSelector
+	switch {
+	case strings.Contains(selector, "!="):
+		parts := strings.SplitN(selector, "!=", 2)
+		s = LabelSelector{key: parts[0], value: parts[1], hasValue: true, negate: true}
+	case strings.Contains(selector, "="):
+		parts := strings.SplitN(selector, "=", 2)
+		s = LabelSelector{key: parts[0], value: parts[1], hasValue: true}
+	case strings.HasPrefix(selector, "!"):
+		s = LabelSelector{key: selector[1:], negate: true}
+	default:
+		s = LabelSelector{key: selector}
+	}
+	s.key = strings.TrimSpace(s.key)
+	if s.key == "" {
+		return s, fmt.Errorf("invalid label filter: %q", selector)
+	}
+	return s, nil
+}
+
+// matches reports whether the labels satisfy the selector.
+func (s LabelSelector) matches(labels map[string]string) bool {
+	value, ok := labels[s.key]
+	if s.hasValue {
+		ok = ok && value == s.value
+	}
+	return ok != s.negate
+}
+
+// String formats the selector the way it was written.
+func (s LabelSelector) String() string {
+	switch {
+	case s.hasValue && s.negate:
+		return s.key + "!=" + s.value
+	case s.hasValue:
+		return s.key + "=" + s.value
+	case s.negate:
+		return "!" + s.key
+	}
+	return s.key
+}
+
+// keptByLabels returns a reason to keep a resource with the given labels, or an empty
+// string if its labels allow it to be collected.
+func (p *Policy) keptByLabels(labels map[string]string) string {
+	if keep, err := strconv.ParseBool(labels[keepLabel]); err == nil && keep {
+		return "protected by label " + keepLabel
+	}
+	for _, selector := range p.Labels {
+		if !selector.matches(labels) {
+			return "doesn't match label filter " + selector.String()
+		}
+	}
+	return ""
+}
+
+// grace returns the grace period for a resource with the given labels, which is the
+// default grace unless overridden by the dgc.grace label.
+func (p *Policy) grace(labels map[string]string) time.Duration {
+	value, ok := labels[graceLabel]
+	if !ok {
+		return p.Grace
+	}
+	override, err := time.ParseDuration(value)
+	if err != nil {
+		return p.Grace
+	}
+	return override
+}
@@ -0,0 +1,154 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// reconnectDelay is how long to wait before reopening a failed events stream.
+const reconnectDelay = 5 * time.Second
+
+// LastUsed records when each image was last used by a container, per docker host, so
+// that grace periods can be measured from last use instead of build time. A nil
+// *LastUsed records nothing.
+type LastUsed struct {
+	lock  sync.Mutex
+	path  string
+	Hosts map[string]map[string]time.Time `json:"hosts"`
+}
+
+// LoadLastUsed reads the last used times from a state file. A missing file is treated
+// as empty, since it is created on the first save.
+func LoadLastUsed(path string) (*LastUsed, error) {
+	usage := &LastUsed{path: path, Hosts: make(map[string]map[string]time.Time)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, usage); err != nil {
+		return nil, err
+	}
+	if usage.Hosts == nil {
+		usage.Hosts = make(map[string]map[string]time.Time)
+	}
+	return usage, nil
+}
+
+// record notes that the image was used on the host at the given time.
+func (u *LastUsed) record(socket, imageID string, at time.Time) {
+	if u == nil || imageID == "" {
+		return
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	images, ok := u.Hosts[socket]
+	if !ok {
+		images = make(map[string]time.Time)
+		u.Hosts[socket] = images
+	}
+	if at.After(images[imageID]) {
+		images[imageID] = at
+	}
+}
+
+// get returns when the image was last used on the host, or the zero time if unknown.
+func (u *LastUsed) get(socket, imageID string) time.Time {
+	if u == nil {
+		return time.Time{}
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.Hosts[socket][imageID]
+}
+
+// forget drops the images that no longer exist on the host.
+func (u *LastUsed) forget(socket string, images []dockerTypes.ImageSummary) {
+	if u == nil {
+		return
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	existing := make(map[string]bool)
+	for _, image := range images {
+		existing[image.ID] = true
+	}
+	for id := range u.Hosts[socket] {
+		if !existing[id] {
+			delete(u.Hosts[socket], id)
+		}
+	}
+}
+
+// Save writes the last used times to the state file, replacing it atomically.
+func (u *LastUsed) Save() error {
+	if u == nil {
+		return nil
+	}
+	u.lock.Lock()
+	data, err := json.MarshalIndent(u, "", "  ")
+	u.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(u.path, data)
+}
+
+// watchImageUsage records the image of every container created or started on the host
+// until ctx is cancelled. The events stream is reopened whenever it fails, picking up
+// from the last event seen so that none are missed.
+func watchImageUsage(ctx context.Context, host *dockerHost, usage *LastUsed) {
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("event", "create")
+	args.Add("event", "start")
+	since := time.Now()
+
+	for {
+		options := dockerTypes.EventsOptions{
+			Since:   strconv.FormatInt(since.Unix(), 10),
+			Filters: args,
+		}
+		messages, errs := host.client.Events(ctx, options)
+	stream:
+		for {
+			select {
+			case message := <-messages:
+				at := time.Unix(0, message.TimeNano)
+				if at.After(since) {
+					since = at
+				}
+				// The event only names the image, so look up the ID the container runs
+				container, err := host.client.ContainerInspect(ctx, message.Actor.ID)
+				if err != nil {
+					host.log.Printf("Error. Failed to inspect container: %s: %s\n", message.Actor.ID, err)
+					continue
+				}
+				usage.record(host.socket, container.Image, at)
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				host.log.Printf("Error. Lost the events stream, reconnecting: %s\n", err)
+				break stream
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+)
+
+// builtinNetworks are created by the docker daemon itself and can never be removed.
+var builtinNetworks = map[string]bool{
+	"bridge": true,
+	"host":   true,
+	"none":   true,
+}
+
+// networkSubject returns what rules are matched against for the network.
+func networkSubject(network dockerTypes.NetworkResource) subject {
+	return subject{names: []string{network.ID, network.Name}, labels: network.Labels}
+}
+
+// collectAPINetworks deletes the user-defined networks that are outside the grace
+// period and have no endpoints attached, other than those of containers that are
+// about to be removed.
+func collectAPINetworks(networks []dockerTypes.NetworkResource, removed map[string]bool, host *dockerHost) TypeResult {
+	var networkSync sync.WaitGroup
+	var resultLock sync.Mutex
+	result := newTypeResult(host, "network")
+	dryRun := host.dryRun
+
+	host.state.check(host.socket, "network")
+networkLoop:
+	for _, network := range networks {
+		result.Examined++
+		c := Candidate{Kind: "network", ID: network.ID, Names: []string{network.Name}}
+		if builtinNetworks[network.Name] || network.Ingress {
+			result.keep(c, "builtin", "created by the docker daemon")
+			continue
+		}
+
+		// Check if the network id or name is on excludes list
+		if entry, excluded := host.policy.Excludes.match(network.ID, network.Name); excluded {
+			result.keep(c, "excluded", "excluded by "+entry)
+			continue
+		}
+
+		// Skip the network if its labels protect it or don't match the label filters
+		if reason := host.policy.keptByLabels(network.Labels); reason != "" {
+			result.keep(c, "label", reason)
+			continue
+		}
+
+		// Listing networks doesn't report endpoints, so inspect the network for them
+		host.log.Printf("Inspecting network: %s\n", network.ID)
+
+		inspected, err := host.client.NetworkInspect(context.Background(), network.ID, false)
+		if err != nil {
+			host.log.Printf("Error. Failed to inspect network: %s: %s\n", network.ID, err)
+			result.keep(c, "inspect_failed", "failed to inspect: "+err.Error())
+			continue
+		}
+		for containerID := range inspected.Containers {
+			if !removed[containerID] {
+				result.keep(c, "in_use", "a container is attached")
+				continue networkLoop
+			}
+		}
+
+		// Skip the network if it is still in the grace period, measured from when it
+		// was created or first seen unused
+		age := time.Now().Sub(host.state.since(host.socket, "network", network.ID, network.Created))
+		rule, _ := host.policy.matchRule("network", networkSubject(network), func() (time.Duration, error) {
+			return age, nil
+		})
+		if rule.keeps() {
+			result.keep(c, "rule", ruleKept(rule))
+			continue
+		}
+		grace := rule.graceOf(host.policy.grace(network.Labels))
+		if age < grace {
+			result.keep(c, "grace", rule.graceKept(grace))
+			continue
+		}
+
+		c.Age = age
+		c.Reason, c.Rule = rule.selected(grace)
+		if dryRun {
+			result.plan(c)
+			continue
+		}
+
+		// Delete network
+		networkSync.Add(1)
+		id := network.ID
+		host.workers.Go(func() {
+			defer networkSync.Done()
+
+			host.log.Printf("Deleting network: %s\n", id)
+
+			err := host.client.NetworkRemove(context.Background(), id)
+			resultLock.Lock()
+			defer resultLock.Unlock()
+			if err == nil {
+				result.deleted(c)
+				host.log.Printf("Deleted network: %s\n", id)
+			} else {
+				result.failed(c, err)
+				host.log.Printf("Error. Failed to delete network: %s: %s\n", id, err)
+			}
+		})
+	}
+
+	networkSync.Wait()
+	sortByAge(result.Planned)
+	return result
+}
@@ -0,0 +1,51 @@
+package gc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Candidate is a resource that passed every check and was selected for collection.
+type Candidate struct {
+	Kind  string        `json:"kind"`
+	ID    string        `json:"id"`
+	Names []string      `json:"names,omitempty"`
+	Age   time.Duration `json:"age"`
+	Size  int64         `json:"size"`
+	// Reason names the kind of rule that selected the resource, "grace" or "rule",
+	// and Rule describes it.
+	Reason string `json:"reason"`
+	Rule   string `json:"rule"`
+}
+
+// graceRule describes a resource selected because it outlived the grace period.
+func graceRule(grace time.Duration) string {
+	return fmt.Sprintf("older than grace period %s", grace)
+}
+
+// graceKept describes a resource kept because it is still in the grace period.
+func graceKept(grace time.Duration) string {
+	return fmt.Sprintf("younger than grace period %s", grace)
+}
+
+// watermarkKept describes a resource kept because enough space was already reclaimed.
+const watermarkKept = "disk usage is below the low watermark"
+
+// sortByAge sorts candidates oldest first.
+func sortByAge(candidates []Candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Age > candidates[j].Age
+	})
+}
+
+// sortByValue sorts candidates least valuable first: the oldest, and of those the
+// largest, go first.
+func sortByValue(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Age != candidates[j].Age {
+			return candidates[i].Age > candidates[j].Age
+		}
+		return candidates[i].Size > candidates[j].Size
+	})
+}
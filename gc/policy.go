@@ -0,0 +1,39 @@
+package gc
+
+import (
+	"time"
+)
+
+// Policy decides which resources a Collector deletes and how.
+type Policy struct {
+	// Grace is how long a resource must have gone unused before it is collected,
+	// unless its dgc.grace label says otherwise.
+	Grace time.Duration
+	// Excludes lists the IDs, names and tags that are never collected.
+	Excludes ExcludeList
+	// Labels restricts collection to resources whose labels match every selector.
+	Labels []LabelSelector
+	// Retention keeps the newest images of repositories, see ParseRetentionRules.
+	Retention []RetentionRule
+	// States are the container states that are collected, see ParseStates.
+	States map[string]bool
+	// Watermarks, if set, hold off collection until disk usage crosses the high
+	// watermark and stop it once usage falls below the low watermark.
+	Watermarks *Watermarks
+	// Rules hold the rules for each kind of resource, such as "image", in the order
+	// they are tried. See Rule.
+	Rules map[string][]Rule
+
+	// Volumes also collects dangling volumes, narrowed down by the VolumeLabels filters.
+	Volumes      bool
+	VolumeLabels []string
+	// Networks also collects unused user-defined networks.
+	Networks bool
+
+	// RemoveVolumes removes the anonymous volumes of deleted containers.
+	RemoveVolumes bool
+	// Force removes running containers and images with multiple tags.
+	Force bool
+	// DryRun plans what would be collected instead of deleting anything.
+	DryRun bool
+}
@@ -0,0 +1,77 @@
+package gc
+
+import (
+	"time"
+)
+
+// WorkerPool bounds how many deletions run at once across every host.
+type WorkerPool struct {
+	slots chan struct{}
+}
+
+// NewWorkerPool creates a pool that runs at most size deletions at once.
+func NewWorkerPool(size int) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &WorkerPool{slots: make(chan struct{}, size)}
+}
+
+// HostWorkers runs the deletions for a single host on a shared worker pool.
+type HostWorkers struct {
+	pool   *WorkerPool
+	slots  chan struct{}
+	ticker *time.Ticker
+}
+
+// ForHost creates the workers for a single host. At most limit deletions run at
+// once on the host, or as many as the shared pool allows if limit is zero. When
+// rate is positive, deletions are started at most rate times per second.
+func (p *WorkerPool) ForHost(limit int, rate float64) *HostWorkers {
+	if limit < 1 {
+		limit = cap(p.slots)
+	}
+	w := &HostWorkers{
+		pool:  p,
+		slots: make(chan struct{}, limit),
+	}
+	if rate > 0 {
+		// Rates beyond one per nanosecond round down to no interval at all, which
+		// the ticker refuses
+		interval := time.Duration(float64(time.Second) / rate)
+		if interval < 1 {
+			interval = 1
+		}
+		w.ticker = time.NewTicker(interval)
+	}
+	return w
+}
+
+// Go runs task on the pool. It blocks until a worker is free for the host and the
+// rate limit allows another deletion. A nil *HostWorkers runs task right away and
+// waits for it to finish.
+func (w *HostWorkers) Go(task func()) {
+	if w == nil {
+		task()
+		return
+	}
+	w.slots <- struct{}{}
+	w.pool.slots <- struct{}{}
+	if w.ticker != nil {
+		<-w.ticker.C
+	}
+	go func() {
+		defer func() {
+			<-w.pool.slots
+			<-w.slots
+		}()
+		task()
+	}()
+}
+
+// Stop releases the rate limiter. Tasks that are already running are unaffected.
+func (w *HostWorkers) Stop() {
+	if w != nil && w.ticker != nil {
+		w.ticker.Stop()
+	}
+}
@@ -0,0 +1,17 @@
+package gc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestForHostRate(t *testing.T) {
+	pool := NewWorkerPool(2)
+	for _, rate := range []float64{100, 1e9, 2e9, math.Inf(1)} {
+		workers := pool.ForHost(1, rate)
+		done := make(chan struct{})
+		workers.Go(func() { close(done) })
+		<-done
+		workers.Stop()
+	}
+}
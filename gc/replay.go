@@ -0,0 +1,191 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	volumeTypes "github.com/docker/docker/api/types/volume"
+)
+
+// errReadOnly is returned for requests that would change a snapshot.
+var errReadOnly = errors.New("a snapshot can't be changed, only dry runs can be made against it")
+
+// snapshotClient answers the requests of a dry run from a snapshot instead of a docker
+// daemon. Every timestamp is moved forward by the time that has passed since the
+// snapshot was taken, so resources are as old as they were back then.
+type snapshotClient struct {
+	snapshot *Snapshot
+	shift    time.Duration
+}
+
+// Client returns a Client that replays the snapshot as if it had just been taken. It
+// refuses to delete anything, so the collector's policy must be a dry run.
+func (s *Snapshot) Client() Client {
+	return &snapshotClient{snapshot: s, shift: time.Since(s.Taken)}
+}
+
+// unix moves a time in seconds since the epoch forward by the shift.
+func (c *snapshotClient) unix(seconds int64) int64 {
+	return seconds + int64(c.shift/time.Second)
+}
+
+// timestamp moves an RFC 3339 timestamp forward by the shift. The zero time, which
+// the daemon reports for things that never happened, is left alone.
+func (c *snapshotClient) timestamp(value string) string {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.IsZero() || t.Year() <= 1 {
+		return value
+	}
+	return t.Add(c.shift).Format(time.RFC3339Nano)
+}
+
+// mounted reports whether any container in the snapshot mounts the volume.
+func (c *snapshotClient) mounted(name string) bool {
+	for _, container := range c.snapshot.Containers {
+		for _, mount := range container.Mounts {
+			if mount.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *snapshotClient) ContainerList(ctx context.Context, options dockerTypes.ContainerListOptions) ([]dockerTypes.Container, error) {
+	var containers []dockerTypes.Container
+	for _, sc := range c.snapshot.Containers {
+		if !options.Filters.FuzzyMatch("id", sc.ID) {
+			continue
+		}
+		container := sc.Container
+		container.Created = c.unix(container.Created)
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+func (c *snapshotClient) ContainerInspect(ctx context.Context, containerID string) (dockerTypes.ContainerJSON, error) {
+	for _, sc := range c.snapshot.Containers {
+		if sc.ID != containerID {
+			continue
+		}
+		state := sc.State
+		return dockerTypes.ContainerJSON{
+			ContainerJSONBase: &dockerTypes.ContainerJSONBase{
+				ID:      sc.ID,
+				Created: time.Unix(c.unix(sc.Created), 0).UTC().Format(time.RFC3339Nano),
+				Image:   sc.ImageID,
+				State: &dockerTypes.ContainerState{
+					Status:     state,
+					Running:    state == "running" || state == "paused" || state == "restarting",
+					Paused:     state == "paused",
+					Restarting: state == "restarting",
+					Dead:       state == "dead",
+					FinishedAt: c.timestamp(sc.FinishedAt),
+				},
+			},
+		}, nil
+	}
+	return dockerTypes.ContainerJSON{}, fmt.Errorf("No such container: %s", containerID)
+}
+
+func (c *snapshotClient) ContainerRemove(ctx context.Context, containerID string, options dockerTypes.ContainerRemoveOptions) error {
+	return errReadOnly
+}
+
+func (c *snapshotClient) ImageList(ctx context.Context, options dockerTypes.ImageListOptions) ([]dockerTypes.ImageSummary, error) {
+	var images []dockerTypes.ImageSummary
+	for _, image := range c.snapshot.Images {
+		image.Created = c.unix(image.Created)
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func (c *snapshotClient) ImageRemove(ctx context.Context, imageID string, options dockerTypes.ImageRemoveOptions) ([]dockerTypes.ImageDeleteResponseItem, error) {
+	return nil, errReadOnly
+}
+
+func (c *snapshotClient) VolumeList(ctx context.Context, filter filters.Args) (volumeTypes.VolumesListOKBody, error) {
+	body := volumeTypes.VolumesListOKBody{Volumes: []*dockerTypes.Volume{}}
+	for i := range c.snapshot.Volumes {
+		volume := c.snapshot.Volumes[i].Volume
+		if filter.Include("dangling") {
+			dangling, _ := strconv.ParseBool(filter.Get("dangling")[0])
+			if dangling == c.mounted(volume.Name) {
+				continue
+			}
+		}
+		if !filter.MatchKVList("label", volume.Labels) {
+			continue
+		}
+		body.Volumes = append(body.Volumes, &volume)
+	}
+	return body, nil
+}
+
+func (c *snapshotClient) VolumeInspectWithRaw(ctx context.Context, volumeID string) (dockerTypes.Volume, []byte, error) {
+	for _, volume := range c.snapshot.Volumes {
+		if volume.Name != volumeID {
+			continue
+		}
+		volume.CreatedAt = c.timestamp(volume.CreatedAt)
+		raw, err := json.Marshal(volume)
+		return volume.Volume, raw, err
+	}
+	return dockerTypes.Volume{}, nil, fmt.Errorf("get %s: no such volume", volumeID)
+}
+
+func (c *snapshotClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	return errReadOnly
+}
+
+func (c *snapshotClient) NetworkList(ctx context.Context, options dockerTypes.NetworkListOptions) ([]dockerTypes.NetworkResource, error) {
+	var networks []dockerTypes.NetworkResource
+	for _, network := range c.snapshot.Networks {
+		network.Created = network.Created.Add(c.shift)
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (c *snapshotClient) NetworkInspect(ctx context.Context, networkID string, verbose bool) (dockerTypes.NetworkResource, error) {
+	for _, network := range c.snapshot.Networks {
+		if network.ID == networkID || network.Name == networkID {
+			network.Created = network.Created.Add(c.shift)
+			return network, nil
+		}
+	}
+	return dockerTypes.NetworkResource{}, fmt.Errorf("network %s not found", networkID)
+}
+
+func (c *snapshotClient) NetworkRemove(ctx context.Context, networkID string) error {
+	return errReadOnly
+}
+
+func (c *snapshotClient) DiskUsage(ctx context.Context) (dockerTypes.DiskUsage, error) {
+	du := dockerTypes.DiskUsage{LayersSize: c.snapshot.LayersSize}
+	for i := range c.snapshot.Images {
+		du.Images = append(du.Images, &c.snapshot.Images[i])
+	}
+	for i := range c.snapshot.Containers {
+		du.Containers = append(du.Containers, &c.snapshot.Containers[i].Container)
+	}
+	for i := range c.snapshot.Volumes {
+		du.Volumes = append(du.Volumes, &c.snapshot.Volumes[i].Volume)
+	}
+	return du, nil
+}
+
+func (c *snapshotClient) Events(ctx context.Context, options dockerTypes.EventsOptions) (<-chan events.Message, <-chan error) {
+	errs := make(chan error, 1)
+	errs <- errors.New("a snapshot has no events stream")
+	return nil, errs
+}
@@ -0,0 +1,119 @@
+package gc
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	dockerTypes "github.com/docker/docker/api/types"
+)
+
+// RetentionRule keeps the newest images of every repository matching a glob pattern.
+type RetentionRule struct {
+	pattern string
+	keep    int
+}
+
+// ParseRetentionRules parses the --keep-recent-for values, written as pattern=N, and
+// adds the --keep-recent default for every other repository when it is positive.
+func ParseRetentionRules(keepRecent int, keepRecentFor []string) ([]RetentionRule, error) {
+	var retention []RetentionRule
+	for _, value := range keepRecentFor {
+		i := strings.LastIndex(value, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid retention rule %q, expected pattern=N", value)
+		}
+		pattern := strings.TrimSpace(value[:i])
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid retention rule %q: bad pattern", value)
+		}
+		keep, err := strconv.Atoi(strings.TrimSpace(value[i+1:]))
+		if err != nil || keep < 0 {
+			return nil, fmt.Errorf("invalid retention rule %q: bad count", value)
+		}
+		retention = append(retention, RetentionRule{pattern: pattern, keep: keep})
+	}
+	if keepRecent > 0 {
+		retention = append(retention, RetentionRule{pattern: "*", keep: keepRecent})
+	}
+	return retention, nil
+}
+
+// repositoryOf returns the repository part of an image tag, e.g. myregistry.io:5000/app
+// for myregistry.io:5000/app:1.0.
+func repositoryOf(tag string) string {
+	if i := strings.LastIndex(tag, ":"); i > strings.LastIndex(tag, "/") {
+		return tag[:i]
+	}
+	return tag
+}
+
+// retentionFor returns the first retention rule matching the repository.
+func retentionFor(retention []RetentionRule, repository string) (RetentionRule, bool) {
+	for _, rule := range retention {
+		// Globs can't match across slashes, so * also matches any repository
+		if rule.pattern == "*" {
+			return rule, true
+		}
+		if matched, _ := path.Match(rule.pattern, repository); matched {
+			return rule, true
+		}
+	}
+	return RetentionRule{}, false
+}
+
+// retainImages applies the retention rules to the images. The newest images of each
+// repository with a rule are kept regardless of age, and the reasons are returned in
+// kept. Images beyond the newest of every repository they are tagged in are returned
+// in released. They are only eligible for collection, the grace period and the rules
+// still apply to them.
+func retainImages(images []dockerTypes.ImageSummary, retention []RetentionRule) (kept, released map[string]string) {
+	kept = make(map[string]string)
+	released = make(map[string]string)
+	if len(retention) == 0 {
+		return kept, released
+	}
+
+	repositories := make(map[string][]dockerTypes.ImageSummary)
+	for _, image := range images {
+		seen := make(map[string]bool)
+		for _, tag := range image.RepoTags {
+			repository := repositoryOf(tag)
+			if repository == "<none>" || seen[repository] {
+				continue
+			}
+			seen[repository] = true
+			repositories[repository] = append(repositories[repository], image)
+		}
+	}
+
+	// An image is only released if every repository it is tagged in releases it
+	unreleased := make(map[string]bool)
+	for repository, repoImages := range repositories {
+		rule, ok := retentionFor(retention, repository)
+		if !ok {
+			for _, image := range repoImages {
+				unreleased[image.ID] = true
+			}
+			continue
+		}
+		sort.Slice(repoImages, func(i, j int) bool {
+			return repoImages[i].Created > repoImages[j].Created
+		})
+		for i, image := range repoImages {
+			if i < rule.keep {
+				kept[image.ID] = fmt.Sprintf("one of the newest %d images of %s", rule.keep, repository)
+			} else if _, ok := released[image.ID]; !ok {
+				released[image.ID] = fmt.Sprintf("older than the newest %d images of %s", rule.keep, repository)
+			}
+		}
+	}
+	for id := range released {
+		if kept[id] != "" || unreleased[id] {
+			delete(released, id)
+		}
+	}
+	return kept, released
+}
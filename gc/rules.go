@@ -0,0 +1,171 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The actions a rule takes on the resources it matches.
+const (
+	// ActionKeep never collects the resource.
+	ActionKeep = "keep"
+	// ActionDelete collects the resource regardless of the grace period.
+	ActionDelete = "delete"
+	// ActionDeleteAfter collects the resource once it has gone unused for longer than
+	// the rule's After, instead of the grace period.
+	ActionDeleteAfter = "delete-after"
+)
+
+// errUnknownAge is returned when a rule needs the age of a resource the daemon doesn't
+// report it for.
+var errUnknownAge = errors.New("the daemon doesn't report when it was created")
+
+// Rule decides what happens to the resources of one type that it matches. Every
+// condition that is set must hold for a rule to match, and only the first rule that
+// matches a resource applies to it. Rules come after the exclude list, the label
+// filters, the container states and whether a resource is in use, and before
+// retention rules and the grace period.
+type Rule struct {
+	// Name identifies the rule in decisions, e.g. "images rule 2".
+	Name string
+	// Names matches the IDs, names and tags a resource is known by, written like
+	// exclude entries.
+	Names ExcludeList
+	// Labels must all match the labels of the resource.
+	Labels []LabelSelector
+	// States are the states a container must be in, or nil for any.
+	States map[string]bool
+	// OlderThan is how long the resource must have gone unused.
+	OlderThan time.Duration
+	// Action is ActionKeep, ActionDelete or ActionDeleteAfter.
+	Action string
+	// After is the grace period of ActionDeleteAfter.
+	After time.Duration
+}
+
+// subject is what a rule is matched against.
+type subject struct {
+	// names are the IDs, names and tags the resource is known by.
+	names  []string
+	labels map[string]string
+	// state is the state of a container, empty for other resources.
+	state string
+}
+
+// matches reports whether the rule matches the resource. age is only called when the
+// rule has an age condition, since finding out may take another request.
+func (r *Rule) matches(s subject, age func() (time.Duration, error)) (bool, error) {
+	if len(r.Names) > 0 {
+		if _, ok := r.Names.match(s.names...); !ok {
+			return false, nil
+		}
+	}
+	for _, selector := range r.Labels {
+		if !selector.matches(s.labels) {
+			return false, nil
+		}
+	}
+	if r.States != nil && !r.States[s.state] {
+		return false, nil
+	}
+	if r.OlderThan > 0 {
+		unused, err := age()
+		if err != nil {
+			return false, err
+		}
+		return unused >= r.OlderThan, nil
+	}
+	return true, nil
+}
+
+// String describes the rule by its name and action, e.g. "images rule 2: delete after 72h".
+func (r *Rule) String() string {
+	if r.Action == ActionDeleteAfter {
+		return fmt.Sprintf("%s: delete after %s", r.Name, r.After)
+	}
+	return r.Name + ": " + r.Action
+}
+
+// Conditions describes what the rule matches, e.g. "label team=ci and older than 2h0m0s",
+// or returns an empty string if it matches every resource.
+func (r *Rule) Conditions() string {
+	var conditions []string
+	for _, pattern := range r.Names {
+		conditions = append(conditions, "name "+pattern.text)
+	}
+	for _, selector := range r.Labels {
+		conditions = append(conditions, "label "+selector.String())
+	}
+	if r.States != nil {
+		var states []string
+		for state := range r.States {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		conditions = append(conditions, "state "+strings.Join(states, "|"))
+	}
+	if r.OlderThan > 0 {
+		conditions = append(conditions, "older than "+r.OlderThan.String())
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// matchRule returns the first rule for the kind of resource that matches it, or nil if
+// none do.
+func (p *Policy) matchRule(kind string, s subject, age func() (time.Duration, error)) (*Rule, error) {
+	rules := p.Rules[kind]
+	for i := range rules {
+		ok, err := rules[i].matches(s, age)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", rules[i].Name, err)
+		}
+		if ok {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// keeps reports whether the rule keeps the resources it matches.
+func (r *Rule) keeps() bool {
+	return r != nil && r.Action == ActionKeep
+}
+
+// graceOf returns the grace period of a resource the rule matched, given the one it
+// would have had otherwise. A nil rule matched nothing and leaves it as it is.
+func (r *Rule) graceOf(grace time.Duration) time.Duration {
+	switch {
+	case r == nil:
+		return grace
+	case r.Action == ActionDelete:
+		return 0
+	case r.Action == ActionDeleteAfter:
+		return r.After
+	}
+	return grace
+}
+
+// selected returns the reason and rule a resource that outlived its grace period is
+// selected with.
+func (r *Rule) selected(grace time.Duration) (string, string) {
+	if r == nil {
+		return "grace", graceRule(grace)
+	}
+	return "rule", r.String()
+}
+
+// graceKept describes a resource kept because it is still in its grace period.
+func (r *Rule) graceKept(grace time.Duration) string {
+	if r == nil {
+		return graceKept(grace)
+	}
+	return graceKept(grace) + " set by " + r.Name
+}
+
+// ruleKept describes a resource kept by a rule.
+func ruleKept(r *Rule) string {
+	return "kept by " + r.Name
+}
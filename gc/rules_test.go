@@ -0,0 +1,81 @@
+package gc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hatchery/dgc/gc/gctest"
+)
+
+const rulesPolicy = `
+grace: 1h
+rules:
+  containers:
+    - match:
+        names: ["/ci-*"]
+        labels: ["team=ci"]
+      action: delete
+  images:
+    - match: {names: ["*:latest"]}
+      action: keep
+    - match: {names: ["build:*"], older-than: 2h}
+      action: delete
+    - match: {names: ["base:*"]}
+      action: delete-after
+      after: 72h
+`
+
+func TestCollectRules(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "ci", Names: []string{"/ci-1"}, State: "exited", Created: ago(3 * time.Minute), Finished: ago(time.Minute),
+		Labels: map[string]string{"team": "ci"}})
+	server.AddContainer(gctest.Container{ID: "web", Names: []string{"/web"}, State: "exited", Created: ago(3 * time.Minute), Finished: ago(time.Minute)})
+	server.AddContainer(gctest.Container{ID: "running", Names: []string{"/ci-2"}, State: "running", Created: ago(3 * time.Hour),
+		Labels: map[string]string{"team": "ci"}})
+	server.AddImage(gctest.Image{ID: "sha256:latest", RepoTags: []string{"app:latest"}, Created: ago(48 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:build", RepoTags: []string{"build:1"}, Created: ago(3 * time.Hour)})
+	server.AddImage(gctest.Image{ID: "sha256:young", RepoTags: []string{"build:2"}, Created: ago(30 * time.Minute)})
+	server.AddImage(gctest.Image{ID: "sha256:base", RepoTags: []string{"base:1"}, Created: ago(48 * time.Hour)})
+
+	policy, err := ParsePolicy([]byte(rulesPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := newTestCollector(t, server, policy).Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	expectDecisions(t, result, map[string]string{
+		"ci":            "deleted rule",
+		"web":           "kept grace",
+		"running":       "kept state",
+		"sha256:latest": "kept rule",
+		"sha256:build":  "deleted rule",
+		"sha256:young":  "kept grace",
+		"sha256:base":   "kept grace",
+	})
+}
+
+func TestParsePolicyRules(t *testing.T) {
+	for _, invalid := range []string{
+		"rules:\n  images:\n    - action: remove\n",
+		"rules:\n  images:\n    - match: {states: [exited]}\n      action: delete\n",
+		"rules:\n  containers:\n    - match: {states: [stopped]}\n      action: delete\n",
+		"rules:\n  containers:\n    - match: {states: [running]}\n      action: delete\n",
+		"states: [exited]\nrules:\n  containers:\n    - match: {states: [exited, dead]}\n      action: delete\n",
+		"rules:\n  containers:\n    - action: delete-after\n",
+		"rules:\n  containers:\n    - action: keep\n      after: 1h\n",
+		"rules:\n  volumes:\n    - action: delete\n",
+		"rules:\n  images:\n    - match: {names: [\"re:(\"]}\n      action: delete\n",
+	} {
+		if _, err := ParsePolicy([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+	// Rule states must be among the states that are collected
+	if _, err := ParsePolicy([]byte("states: [exited, running]\nrules:\n  containers:\n    - match: {states: [running]}\n      action: delete\n")); err != nil {
+		t.Errorf("got %s, want running containers collected by the rule", err)
+	}
+}
@@ -0,0 +1,133 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Snapshot is everything a docker host reported about its containers, images, volumes
+// and networks at one moment, which is enough to plan a collection pass offline.
+type Snapshot struct {
+	Socket     string                        `json:"socket"`
+	Taken      time.Time                     `json:"taken"`
+	Containers []SnapshotContainer           `json:"containers"`
+	Images     []dockerTypes.ImageSummary    `json:"images"`
+	Volumes    []SnapshotVolume              `json:"volumes"`
+	Networks   []dockerTypes.NetworkResource `json:"networks"`
+	// LayersSize is the space taken by image layers, counting shared layers once.
+	LayersSize int64 `json:"layers_size"`
+}
+
+// SnapshotContainer is a container as listed by the daemon, along with when it last
+// finished running.
+type SnapshotContainer struct {
+	dockerTypes.Container
+	FinishedAt string `json:",omitempty"`
+}
+
+// SnapshotVolume is a volume as listed by the daemon, along with when it was created
+// if the daemon reports that.
+type SnapshotVolume struct {
+	dockerTypes.Volume
+	CreatedAt string `json:",omitempty"`
+}
+
+// Snapshot records the containers, images, volumes and networks of the docker host.
+// Images carry their exclusive sizes and volumes their sizes when the daemon can
+// report disk usage, and networks are inspected for the containers attached to them.
+func (c *Collector) Snapshot() (*Snapshot, error) {
+	host := c.host()
+	ctx := context.Background()
+	snapshot := &Snapshot{Socket: host.socket, Taken: time.Now()}
+
+	du, err := host.client.DiskUsage(ctx)
+	if err != nil {
+		host.log.Printf("Error. Failed to measure disk usage on the docker host: %s\n", err)
+	}
+	snapshot.LayersSize = du.LayersSize
+
+	host.log.Println("Getting a list of containers...")
+	containers, err := host.client.ContainerList(ctx, dockerTypes.ContainerListOptions{All: true, Size: true})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve containers from the docker host: %s", err)
+	}
+	for _, container := range containers {
+		sc := SnapshotContainer{Container: container}
+		inspected, err := host.client.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			host.log.Printf("Error. Failed to inspect container: %s: %s\n", container.ID, err)
+		} else if inspected.ContainerJSONBase != nil && inspected.State != nil {
+			sc.FinishedAt = inspected.State.FinishedAt
+		}
+		snapshot.Containers = append(snapshot.Containers, sc)
+	}
+
+	host.log.Println("Getting a list of images...")
+	if snapshot.Images, err = host.client.ImageList(ctx, dockerTypes.ImageListOptions{All: true}); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve images from the docker host: %s", err)
+	}
+	// Only disk usage tells the size shared with other images
+	shared := make(map[string]int64)
+	for _, image := range du.Images {
+		shared[image.ID] = image.SharedSize
+	}
+	for i, image := range snapshot.Images {
+		if size, ok := shared[image.ID]; ok {
+			snapshot.Images[i].SharedSize = size
+		}
+	}
+
+	host.log.Println("Getting a list of volumes...")
+	volumes, err := host.client.VolumeList(ctx, filters.NewArgs())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve volumes from the docker host: %s", err)
+	}
+	usage := make(map[string]*dockerTypes.VolumeUsageData)
+	for _, volume := range du.Volumes {
+		usage[volume.Name] = volume.UsageData
+	}
+	for _, volume := range volumes.Volumes {
+		sv := SnapshotVolume{Volume: *volume}
+		if sv.UsageData == nil {
+			sv.UsageData = usage[volume.Name]
+		}
+		if created, ok := volumeCreated(host, volume.Name); ok {
+			sv.CreatedAt = created.Format(time.RFC3339Nano)
+		}
+		snapshot.Volumes = append(snapshot.Volumes, sv)
+	}
+
+	host.log.Println("Getting a list of networks...")
+	networks, err := host.client.NetworkList(ctx, dockerTypes.NetworkListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve networks from the docker host: %s", err)
+	}
+	for _, network := range networks {
+		inspected, err := host.client.NetworkInspect(ctx, network.ID, false)
+		if err != nil {
+			host.log.Printf("Error. Failed to inspect network: %s: %s\n", network.ID, err)
+			inspected = network
+		}
+		snapshot.Networks = append(snapshot.Networks, inspected)
+	}
+	return snapshot, nil
+}
+
+// ReadSnapshot reads a snapshot written as JSON.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,54 @@
+package gc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hatchery/dgc/gc/gctest"
+)
+
+func TestSimulateSnapshot(t *testing.T) {
+	server := gctest.NewServer()
+	defer server.Close()
+	server.AddContainer(gctest.Container{ID: "old", State: "exited", ImageID: "sha256:old", Created: ago(3 * time.Hour), Finished: ago(2 * time.Hour), SizeRw: 100})
+	server.AddContainer(gctest.Container{ID: "recent", State: "exited", ImageID: "sha256:used", Created: ago(3 * time.Hour), Finished: ago(50 * time.Minute)})
+	server.AddImage(gctest.Image{ID: "sha256:old", RepoTags: []string{"app:1"}, Created: ago(3 * time.Hour), Size: 300})
+	server.AddImage(gctest.Image{ID: "sha256:used", RepoTags: []string{"app:2"}, Created: ago(3 * time.Hour), Size: 300})
+
+	taken, err := newTestCollector(t, server, nil).Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	// Ages are as they were when the snapshot was taken, however long ago that was
+	snapshot.Taken = snapshot.Taken.Add(-30 * time.Minute)
+
+	policy := &Policy{Grace: time.Hour, States: ParseStates("exited"), DryRun: true}
+	collector := &Collector{Client: snapshot.Client(), Socket: snapshot.Socket, Policy: policy}
+	result := collector.Collect()
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	expectDecisions(t, result, map[string]string{
+		"old":         "planned grace",
+		"recent":      "kept grace",
+		"sha256:old":  "planned grace",
+		"sha256:used": "kept in_use",
+	})
+	var reclaimable int64
+	for _, c := range result.Planned() {
+		reclaimable += c.Size
+	}
+	if reclaimable != 400 {
+		t.Errorf("got %d bytes reclaimable, want 400", reclaimable)
+	}
+}
@@ -0,0 +1,225 @@
+package gc
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// firstSeenFile holds when resources were first seen unused, in the state directory.
+	firstSeenFile = "first-seen.json"
+	// LastUsedFile holds when images were last used, in the state directory.
+	LastUsedFile = "last-used.json"
+	// stateLockFile is locked while a dgc process is using the state directory.
+	stateLockFile = "state.lock"
+)
+
+// later returns the later of two times.
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// WriteFileAtomic replaces the file with data, so that readers never see it half written.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// FirstSeen records, per docker host, when each resource was first seen unused. Grace
+// periods are measured from then, and a resource that is used again starts over. A nil
+// *FirstSeen records nothing.
+type FirstSeen struct {
+	lock  sync.Mutex
+	dir   string
+	file  *os.File
+	Hosts map[string]map[string]time.Time `json:"hosts"`
+	// seen holds the resources seen unused during this pass, and checked the kinds of
+	// resources that were looked at, per host. added holds those first seen during
+	// this pass, which Reset forgets again.
+	seen    map[string]map[string]bool
+	checked map[string]map[string]bool
+	added   map[string]map[string]bool
+}
+
+// OpenState locks the state directory and reads the first seen times from it. The lock
+// is held until the state is closed, so only one dgc process uses the directory at once.
+func OpenState(dir string) (*FirstSeen, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, stateLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	state, err := ReadState(dir)
+	if err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	state.file = file
+	return state, nil
+}
+
+// ReadState reads the first seen times from the state directory without locking it,
+// for commands that only look at them while a running dgc may hold the lock. The
+// state file is replaced whole, so it is never read half written, but a state read
+// this way can't be saved.
+func ReadState(dir string) (*FirstSeen, error) {
+	state := &FirstSeen{
+		dir:     dir,
+		Hosts:   make(map[string]map[string]time.Time),
+		seen:    make(map[string]map[string]bool),
+		checked: make(map[string]map[string]bool),
+		added:   make(map[string]map[string]bool),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, firstSeenFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Hosts == nil {
+		state.Hosts = make(map[string]map[string]time.Time)
+	}
+	return state, nil
+}
+
+// since records that the resource is unused and returns the later of t and when it was
+// first seen unused. Resources are keyed by kind and ID, e.g. "image/sha256:...".
+func (s *FirstSeen) since(socket, kind, id string, t time.Time) time.Time {
+	if s == nil {
+		return t
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := kind + "/" + id
+	resources, ok := s.Hosts[socket]
+	if !ok {
+		resources = make(map[string]time.Time)
+		s.Hosts[socket] = resources
+	}
+	first, ok := resources[key]
+	if !ok {
+		first = time.Now()
+		resources[key] = first
+		if s.added[socket] == nil {
+			s.added[socket] = make(map[string]bool)
+		}
+		s.added[socket][key] = true
+	}
+	if s.seen[socket] == nil {
+		s.seen[socket] = make(map[string]bool)
+	}
+	s.seen[socket][key] = true
+	return later(first, t)
+}
+
+// check notes that every unused resource of the kind was looked at on the host, so any
+// that weren't seen unused can be forgotten.
+func (s *FirstSeen) check(socket, kind string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.checked[socket] == nil {
+		s.checked[socket] = make(map[string]bool)
+	}
+	s.checked[socket][kind] = true
+}
+
+// Save forgets the resources of every checked kind that were not seen unused during
+// this pass, because they are in use again or are gone, and writes the state file.
+func (s *FirstSeen) Save() error {
+	if s == nil {
+		return nil
+	}
+	if s.file == nil {
+		return errors.New("the state was read without its lock and can't be saved")
+	}
+	s.lock.Lock()
+	for socket, resources := range s.Hosts {
+		for key := range resources {
+			kind := strings.SplitN(key, "/", 2)[0]
+			if s.checked[socket][kind] && !s.seen[socket][key] {
+				delete(resources, key)
+			}
+		}
+	}
+	s.seen = make(map[string]map[string]bool)
+	s.checked = make(map[string]map[string]bool)
+	s.added = make(map[string]map[string]bool)
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(s.dir, firstSeenFile), data)
+}
+
+// Reset forgets what was seen during a pass that is not saved, such as a dry run, so
+// that it doesn't count towards the next one. Resources first seen during the pass are
+// forgotten too, so their grace period doesn't start before a real pass sees them.
+func (s *FirstSeen) Reset() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for socket, keys := range s.added {
+		for key := range keys {
+			delete(s.Hosts[socket], key)
+		}
+		if len(s.Hosts[socket]) == 0 {
+			delete(s.Hosts, socket)
+		}
+	}
+	s.added = make(map[string]map[string]bool)
+	s.seen = make(map[string]map[string]bool)
+	s.checked = make(map[string]map[string]bool)
+}
+
+// Close releases the lock on the state directory, if it was taken.
+func (s *FirstSeen) Close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+	unlockFile(s.file)
+	return s.file.Close()
+}
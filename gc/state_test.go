@@ -0,0 +1,45 @@
+package gc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadStateWhileLocked(t *testing.T) {
+	dir := t.TempDir()
+	state, err := OpenState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	first := state.since("unix:///var/run/docker.sock", "image", "sha256:app", time.Time{})
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is still held, as it is by a running dgc
+	read := make(chan *FirstSeen, 1)
+	go func() {
+		state, err := ReadState(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		read <- state
+	}()
+	var readOnly *FirstSeen
+	select {
+	case readOnly = <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading the state waited for its lock")
+	}
+	if readOnly == nil {
+		return
+	}
+	defer readOnly.Close()
+	if got := readOnly.Hosts["unix:///var/run/docker.sock"]["image/sha256:app"]; !got.Equal(first) {
+		t.Errorf("got first seen %s, want %s", got, first)
+	}
+	if err := readOnly.Save(); err == nil {
+		t.Error("saved a state that was read without its lock")
+	}
+}
@@ -0,0 +1,175 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	units "github.com/docker/go-units"
+)
+
+// listDanglingVolumes lists the volumes that no container references, optionally
+// narrowed down by the --volume-label filters.
+func listDanglingVolumes(host *dockerHost) ([]*dockerTypes.Volume, error) {
+	args := filters.NewArgs()
+	args.Add("dangling", "true")
+	for _, label := range host.policy.VolumeLabels {
+		args.Add("label", label)
+	}
+	body, err := host.client.VolumeList(context.Background(), args)
+	if err != nil {
+		return nil, err
+	}
+	return body.Volumes, nil
+}
+
+// mountedVolumes returns the names of the volumes mounted by the given containers,
+// whether they are running or not, leaving out any container that is about to be removed.
+func mountedVolumes(containers []dockerTypes.Container, removed map[string]bool) map[string]bool {
+	mounted := make(map[string]bool)
+	for _, container := range containers {
+		if removed[container.ID] {
+			continue
+		}
+		for _, mount := range container.Mounts {
+			if mount.Name != "" {
+				mounted[mount.Name] = true
+			}
+		}
+	}
+	return mounted
+}
+
+// volumeCreated returns when a volume was created. The creation time is only reported
+// by newer daemons and is missing from the vendored Volume type, so it is read from the
+// raw inspect response. The second result is false when the daemon didn't report it.
+func volumeCreated(host *dockerHost, name string) (time.Time, bool) {
+	_, raw, err := host.client.VolumeInspectWithRaw(context.Background(), name)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var volume struct {
+		CreatedAt time.Time
+	}
+	if err := json.Unmarshal(raw, &volume); err != nil || volume.CreatedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return volume.CreatedAt, true
+}
+
+// volumeSubject returns what rules are matched against for the volume.
+func volumeSubject(volume *dockerTypes.Volume) subject {
+	return subject{names: []string{volume.Name}, labels: volume.Labels}
+}
+
+// collectAPIVolumes deletes the dangling volumes that are outside the grace period and
+// are not mounted by any container. sizes holds the size of the volumes by name, where
+// known.
+func collectAPIVolumes(volumes []*dockerTypes.Volume, mounted map[string]bool, sizes map[string]int64, host *dockerHost) TypeResult {
+	var volumeSync sync.WaitGroup
+	var resultLock sync.Mutex
+	result := newTypeResult(host, "volume")
+	dryRun := host.dryRun
+
+	var candidates []Candidate
+	host.state.check(host.socket, "volume")
+	for _, volume := range volumes {
+		result.Examined++
+		c := Candidate{Kind: "volume", ID: volume.Name, Size: sizes[volume.Name]}
+
+		// Check if the volume name is on excludes list
+		if entry, excluded := host.policy.Excludes.match(volume.Name); excluded {
+			result.keep(c, "excluded", "excluded by "+entry)
+			continue
+		}
+
+		// Skip the volume if its labels protect it or don't match the label filters
+		if reason := host.policy.keptByLabels(volume.Labels); reason != "" {
+			result.keep(c, "label", reason)
+			continue
+		}
+
+		// Never touch a volume that a container still mounts
+		if mounted[volume.Name] {
+			result.keep(c, "in_use", "mounted by a container")
+			continue
+		}
+
+		// Skip the volume if it is still in the grace period
+		host.log.Printf("Inspecting volume: %s\n", volume.Name)
+
+		// Older daemons don't report when volumes were created, in which case the grace
+		// period can only be measured from when the volume was first seen unused
+		created, ok := volumeCreated(host, volume.Name)
+		known := ok || host.state != nil
+		age := time.Now().Sub(host.state.since(host.socket, "volume", volume.Name, created))
+		rule, err := host.policy.matchRule("volume", volumeSubject(volume), func() (time.Duration, error) {
+			if !known {
+				return 0, errUnknownAge
+			}
+			return age, nil
+		})
+		if rule.keeps() {
+			result.keep(c, "rule", ruleKept(rule))
+			continue
+		}
+		grace := rule.graceOf(host.policy.grace(volume.Labels))
+		if err != nil || !known && grace > 0 {
+			host.log.Printf("Skipping volume with unknown creation time: %s\n", volume.Name)
+			result.keep(c, "unknown_age", errUnknownAge.Error())
+			continue
+		}
+		if age < grace {
+			result.keep(c, "grace", rule.graceKept(grace))
+			continue
+		}
+
+		c.Age = age
+		c.Reason, c.Rule = rule.selected(grace)
+		candidates = append(candidates, c)
+	}
+
+	// Delete the least valuable volumes first, until enough disk space is reclaimed
+	sortByValue(candidates)
+	for i, c := range candidates {
+		if host.budget.exhausted() {
+			for _, c := range candidates[i:] {
+				result.keep(c, "watermark", watermarkKept)
+			}
+			break
+		}
+		host.budget.spend(c.Size)
+
+		if dryRun {
+			result.plan(c)
+			continue
+		}
+
+		// Delete volume
+		volumeSync.Add(1)
+		c, name, size := c, c.ID, c.Size
+		host.workers.Go(func() {
+			defer volumeSync.Done()
+
+			host.log.Printf("Deleting volume: %s\n", name)
+
+			err := host.client.VolumeRemove(context.Background(), name, false)
+			resultLock.Lock()
+			defer resultLock.Unlock()
+			if err == nil {
+				result.deleted(c)
+				host.log.Printf("Deleted volume: %s (%s)\n", name, units.HumanSize(float64(size)))
+			} else {
+				result.failed(c, err)
+				host.budget.spend(-size)
+				host.log.Printf("Error. Failed to delete volume: %s: %s\n", name, err)
+			}
+		})
+	}
+
+	volumeSync.Wait()
+	return result
+}
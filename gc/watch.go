@@ -0,0 +1,240 @@
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	units "github.com/docker/go-units"
+)
+
+// containerWatcher removes the containers of a single host a grace period after they exit.
+type containerWatcher struct {
+	host    *dockerHost
+	options dockerTypes.ContainerRemoveOptions
+
+	lock sync.Mutex
+	// timers holds the pending removal of each container by ID.
+	timers map[string]*time.Timer
+	// stopping is set once shutdown has begun, after which no removal may start.
+	stopping bool
+	removes  sync.WaitGroup
+}
+
+// lookup lists a single container by ID, along with its size if size is set. The
+// second result is false if it is gone.
+func (w *containerWatcher) lookup(stop context.Context, id string, size bool) (dockerTypes.Container, bool, error) {
+	args := filters.NewArgs()
+	args.Add("id", id)
+	containers, err := w.host.client.ContainerList(stop, dockerTypes.ContainerListOptions{All: true, Size: size, Filters: args})
+	if err != nil || len(containers) == 0 {
+		return dockerTypes.Container{}, false, err
+	}
+	return containers[0], true, nil
+}
+
+// schedule arranges for the container to be removed once its grace period has passed
+// since it finished, replacing any removal that was already scheduled.
+func (w *containerWatcher) schedule(stop context.Context, container dockerTypes.Container) {
+	if kept, _ := containerKept(container, w.host.policy); kept != "" {
+		return
+	}
+	finished, err := containerFinished(w.host, container)
+	if err != nil {
+		w.host.log.Printf("Error. Failed to inspect container: %s: %s\n", container.ID, err)
+		return
+	}
+	age := func() (time.Duration, error) {
+		return time.Since(finished), nil
+	}
+	rule, _ := w.host.policy.matchRule("container", containerSubject(container), age)
+	if rule.keeps() {
+		return
+	}
+	grace := rule.graceOf(w.host.policy.grace(container.Labels))
+	delay := finished.Add(grace).Sub(time.Now())
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if timer, ok := w.timers[container.ID]; ok {
+		timer.Stop()
+	}
+	id := container.ID
+	w.timers[id] = time.AfterFunc(delay, func() {
+		w.remove(stop, id, rule, grace)
+	})
+	if delay > 0 {
+		w.host.log.Printf("Container %s will be collected in %s\n", id, delay)
+	}
+}
+
+// cancel forgets the pending removal of a container.
+func (w *containerWatcher) cancel(id string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if timer, ok := w.timers[id]; ok {
+		timer.Stop()
+		delete(w.timers, id)
+	}
+}
+
+// remove deletes the container, after checking again that it may still be collected.
+// rule is the rule that set its grace period, if any.
+func (w *containerWatcher) remove(stop context.Context, id string, rule *Rule, grace time.Duration) {
+	w.lock.Lock()
+	delete(w.timers, id)
+	w.lock.Unlock()
+	if stop.Err() != nil {
+		return
+	}
+
+	// The size is reported along with the removal
+	container, ok, err := w.lookup(stop, id, true)
+	if err != nil {
+		w.host.log.Printf("Error. Failed to retrieve container: %s: %s\n", id, err)
+		return
+	}
+	// The container may have been removed or restarted in the meantime
+	if !ok {
+		return
+	}
+	if kept, _ := containerKept(container, w.host.policy); kept != "" {
+		return
+	}
+
+	result := newTypeResult(w.host, "container")
+	c := Candidate{Kind: "container", ID: id, Names: container.Names, Size: container.SizeRw}
+	reason, detail := rule.selected(grace)
+	c.Reason, c.Rule = reason, container.State+", "+detail
+	if w.host.dryRun {
+		result.plan(c)
+		return
+	}
+
+	// Shutdown waits for the removals in progress, so none may start once it has begun
+	w.lock.Lock()
+	if w.stopping {
+		w.lock.Unlock()
+		return
+	}
+	w.removes.Add(1)
+	w.lock.Unlock()
+	w.host.workers.Go(func() {
+		defer w.removes.Done()
+
+		w.host.log.Printf("Deleting container: %s\n", id)
+
+		if err := w.host.client.ContainerRemove(context.Background(), id, w.options); err == nil {
+			w.host.log.Printf("Deleted container: %s (%s)\n", id, units.HumanSize(float64(c.Size)))
+			result.deleted(c)
+		} else {
+			w.host.log.Printf("Error. Failed to delete container: %s: %s\n", id, err)
+			result.failed(c, err)
+		}
+	})
+}
+
+// resync schedules the removal of every stopped container, so that no exit is missed
+// while the events stream was down.
+func (w *containerWatcher) resync(stop context.Context) error {
+	containers, err := w.host.client.ContainerList(stop, dockerTypes.ContainerListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		w.schedule(stop, container)
+	}
+	return nil
+}
+
+// watch follows the events stream until stop is cancelled, scheduling every container
+// that dies for removal. The stream is reopened whenever it fails, after which the
+// containers are listed again.
+func (w *containerWatcher) watch(stop context.Context) {
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("event", "die")
+	args.Add("event", "start")
+	args.Add("event", "destroy")
+
+	for {
+		// Subscribe before listing so that nothing happens unseen in between
+		messages, errs := w.host.client.Events(stop, dockerTypes.EventsOptions{Filters: args})
+		if err := w.resync(stop); err != nil {
+			w.host.log.Printf("Error. Failed to retrieve containers from the docker host: %s\n", err)
+		}
+
+	stream:
+		for {
+			select {
+			case message := <-messages:
+				switch message.Action {
+				case "die":
+					container, ok, err := w.lookup(stop, message.Actor.ID, false)
+					if err != nil {
+						w.host.log.Printf("Error. Failed to retrieve container: %s: %s\n", message.Actor.ID, err)
+					} else if ok {
+						w.schedule(stop, container)
+					}
+				case "start", "destroy":
+					w.cancel(message.Actor.ID)
+				}
+			case err := <-errs:
+				if stop.Err() != nil {
+					return
+				}
+				w.host.log.Printf("Error. Lost the events stream, reconnecting: %s\n", err)
+				break stream
+			}
+		}
+
+		select {
+		case <-stop.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// shutdown cancels every pending removal and waits for those in progress.
+func (w *containerWatcher) shutdown() {
+	w.lock.Lock()
+	w.stopping = true
+	for id, timer := range w.timers {
+		timer.Stop()
+		delete(w.timers, id)
+	}
+	w.lock.Unlock()
+	w.removes.Wait()
+}
+
+// Watch removes containers a grace period after they exit, by following the events
+// stream of the docker host, until stop is cancelled. Only the container rules of the
+// policy apply. Every removal is passed to OnRecord, or planned if the policy is a dry
+// run. Watch returns once the removals in progress have finished.
+func (c *Collector) Watch(stop context.Context) {
+	host := c.host()
+	watcher := &containerWatcher{
+		host: host,
+		options: dockerTypes.ContainerRemoveOptions{
+			RemoveVolumes: host.policy.RemoveVolumes,
+			Force:         host.policy.Force,
+		},
+		timers: make(map[string]*time.Timer),
+	}
+	host.log.Println("Watching for containers to exit...")
+	watcher.watch(stop)
+	watcher.shutdown()
+}
+
+// WatchUsage records the image of every container created or started on the docker
+// host in Usage until stop is cancelled. It does nothing if Usage is nil.
+func (c *Collector) WatchUsage(stop context.Context) {
+	if c.Usage == nil {
+		return
+	}
+	watchImageUsage(stop, c.host(), c.Usage)
+}
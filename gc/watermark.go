@@ -0,0 +1,172 @@
+package gc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	units "github.com/docker/go-units"
+)
+
+// watermark is a disk usage threshold, either as a percentage of the disk holding the
+// docker root directory or as an absolute size.
+type watermark struct {
+	relative bool
+	percent  float64
+	bytes    int64
+}
+
+// parseWatermark parses a watermark such as "85%" or "50GB".
+func parseWatermark(value string) (watermark, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return watermark{}, fmt.Errorf("invalid watermark: %s", value)
+		}
+		return watermark{relative: true, percent: percent}, nil
+	}
+	bytes, err := units.FromHumanSize(value)
+	if err != nil {
+		return watermark{}, fmt.Errorf("invalid watermark: %s", value)
+	}
+	return watermark{bytes: bytes}, nil
+}
+
+// threshold returns the watermark in bytes for a disk of the given size.
+func (w watermark) threshold(total int64) int64 {
+	if w.relative {
+		return int64(w.percent / 100 * float64(total))
+	}
+	return w.bytes
+}
+
+// String formats the watermark the way it was written.
+func (w watermark) String() string {
+	if w.relative {
+		return strconv.FormatFloat(w.percent, 'f', -1, 64) + "%"
+	}
+	return units.HumanSize(float64(w.bytes))
+}
+
+// Watermarks are the thresholds that start and stop disk usage driven collection.
+type Watermarks struct {
+	high watermark
+	low  watermark
+	// root is the docker root directory, measured when the watermarks are percentages.
+	root string
+}
+
+// ParseWatermarks parses a high and low watermark, each such as "85%" or "50GB", and
+// the docker root directory that percentages are measured on. It returns nil when high
+// is empty and collection isn't driven by disk usage. low defaults to high.
+func ParseWatermarks(highValue, lowValue, root string) (*Watermarks, error) {
+	if highValue == "" {
+		if lowValue != "" {
+			return nil, fmt.Errorf("--low-watermark requires --high-watermark")
+		}
+		return nil, nil
+	}
+	high, err := parseWatermark(highValue)
+	if err != nil {
+		return nil, err
+	}
+	low := high
+	if lowValue != "" {
+		if low, err = parseWatermark(lowValue); err != nil {
+			return nil, err
+		}
+	}
+	if high.relative != low.relative {
+		return nil, fmt.Errorf("watermarks must both be percentages or both be sizes")
+	}
+	if low.percent > high.percent || low.bytes > high.bytes {
+		return nil, fmt.Errorf("the low watermark must not be above the high watermark")
+	}
+	return &Watermarks{high: high, low: low, root: root}, nil
+}
+
+// Percentage reports whether the watermarks are percentages of the disk, which can only
+// be measured locally, rather than sizes.
+func (w *Watermarks) Percentage() bool {
+	return w.high.relative
+}
+
+// diskUsage returns the bytes in use on the host and the size of its disk. Percentage
+// watermarks measure the disk holding the docker root directory, which only works for
+// local unix sockets. Size watermarks add up what the daemon reports it is using.
+func (w *Watermarks) diskUsage(host *dockerHost) (used, total int64, err error) {
+	if w.high.relative {
+		if !strings.HasPrefix(host.socket, "unix://") {
+			return 0, 0, fmt.Errorf("percentage watermarks need a local unix:// socket, use sizes for %s", host.socket)
+		}
+		return statDisk(w.root)
+	}
+
+	snapshot, _, err := snapshotDisk(host)
+	if err != nil {
+		return 0, 0, err
+	}
+	return snapshot.Total(), 0, nil
+}
+
+// reclaimBudget tracks how many more bytes must be reclaimed to bring disk usage below
+// the low watermark. A nil budget is never exhausted.
+type reclaimBudget struct {
+	lock      sync.Mutex
+	marks     *Watermarks
+	remaining int64
+}
+
+// newReclaimBudget measures the host's disk usage. It returns a nil budget and false if
+// usage is below the high watermark and nothing should be collected.
+func newReclaimBudget(host *dockerHost, marks *Watermarks) (*reclaimBudget, bool, error) {
+	used, total, err := marks.diskUsage(host)
+	if err != nil {
+		return nil, false, err
+	}
+	if used < marks.high.threshold(total) {
+		host.log.Printf("Disk usage %s is below the high watermark %s\n", units.HumanSize(float64(used)), marks.high)
+		return nil, false, nil
+	}
+	budget := &reclaimBudget{marks: marks, remaining: used - marks.low.threshold(total)}
+	host.log.Printf("Disk usage %s is above the high watermark %s, reclaiming %s\n",
+		units.HumanSize(float64(used)), marks.high, units.HumanSize(float64(budget.remaining)))
+	return budget, true, nil
+}
+
+// refresh measures the disk usage again after resources were deleted.
+func (b *reclaimBudget) refresh(host *dockerHost) error {
+	if b == nil {
+		return nil
+	}
+	used, total, err := b.marks.diskUsage(host)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.remaining = used - b.marks.low.threshold(total)
+	return nil
+}
+
+// exhausted reports whether enough has been reclaimed to fall below the low watermark.
+func (b *reclaimBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.remaining <= 0
+}
+
+// spend records that size bytes were, or in a dry run would have been, reclaimed.
+func (b *reclaimBudget) spend(size int64) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.remaining -= size
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	dockerClient "github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
+)
+
+// newCollector returns a collector for the docker host at socket, which logs to stderr.
+func newCollector(socket string, client *dockerClient.Client, policy *gc.Policy) *gc.Collector {
+	return &gc.Collector{
+		Client: gc.NewClient(client),
+		Socket: socket,
+		Policy: policy,
+		Log:    log.New(os.Stderr, "["+socket+"] ", log.LstdFlags),
+	}
+}
+
+// hostPrefix returns what is prepended to lines printed to stdout on behalf of a host,
+// so that output from concurrently collected hosts can be told apart.
+func hostPrefix(socket string, hosts int) string {
+	if hosts > 1 {
+		return "[" + socket + "] "
+	}
+	return ""
+}
+
+// printRecords returns an OnRecord hook that prints the resources deleted from a host,
+// and those a dry run would delete, as soon as that is decided.
+func printRecords(prefix string) func(gc.Record) {
+	return func(rec gc.Record) {
+		switch {
+		case rec.Decision == gc.DecisionPlanned:
+			fmt.Printf(prefix+"Would delete %s: %s\n", rec.Kind, rec.ID)
+		case rec.Decision == gc.DecisionDeleted && rec.Size > 0:
+			fmt.Printf(prefix+"Deleted %s: %s (%s)\n", rec.Kind, rec.ID, units.HumanSize(float64(rec.Size)))
+		case rec.Decision == gc.DecisionDeleted:
+			fmt.Printf(prefix+"Deleted %s: %s\n", rec.Kind, rec.ID)
+		}
+	}
+}
+
+// readHosts reads a list of docker sockets from a file, one per line.
+// Blank lines and lines starting with # are ignored.
+func readHosts(fileName string) ([]string, error) {
+	var hosts []string
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, scanner.Err()
+}
+
+// socketGiven reports whether --socket was given on the command line. urfave/cli also
+// counts the flag as set when it was read from DOCKER_SOCKET or DOCKER_HOST, as it is
+// in a docker-machine shell, so it is only taken as given if it differs from them.
+func socketGiven(ctx *cli.Context) bool {
+	if !ctx.IsSet("socket") {
+		return false
+	}
+	// The flag is read from the first of the variables that is set
+	for _, name := range []string{"DOCKER_SOCKET", "DOCKER_HOST"} {
+		if value, ok := os.LookupEnv(name); ok {
+			return ctx.String("socket") != value
+		}
+	}
+	return true
+}
+
+// dockerSockets returns every docker socket to collect from, taken from the
+// comma separated --socket flag and the --hosts-file flag.
+func dockerSockets(ctx *cli.Context) ([]string, error) {
+	var sockets []string
+	if ctx.String("hosts-file") != "" {
+		hosts, err := readHosts(ctx.String("hosts-file"))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading hosts file: %s", err)
+		}
+		sockets = append(sockets, hosts...)
+	}
+	// The default socket, or the one in the environment, only applies when no hosts
+	// file was given.
+	if len(sockets) == 0 || socketGiven(ctx) {
+		for _, socket := range strings.Split(ctx.String("socket"), ",") {
+			if socket = strings.TrimSpace(socket); socket != "" {
+				sockets = append(sockets, socket)
+			}
+		}
+	}
+	if len(sockets) == 0 {
+		return nil, fmt.Errorf("Error. No docker sockets were given")
+	}
+	return sockets, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// socketsContext returns a context holding the socket flags the way the dgc app sets
+// them, from the environment and then from args.
+func socketsContext(t *testing.T, args ...string) *cli.Context {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "socket, s", Value: "unix:///var/run/docker.sock", EnvVar: "DOCKER_SOCKET,DOCKER_HOST"},
+		cli.StringFlag{Name: "hosts-file"},
+	}
+	set := flag.NewFlagSet("dgc", flag.ContinueOnError)
+	for _, f := range app.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, set, nil)
+}
+
+func TestDockerSocketsWithHostsFile(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	if err := ioutil.WriteFile(hostsFile, []byte("tcp://a:2376\n# comment\ntcp://b:2376\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A docker-machine shell points DOCKER_HOST at its machine
+	t.Setenv("DOCKER_SOCKET", "")
+	os.Unsetenv("DOCKER_SOCKET")
+	t.Setenv("DOCKER_HOST", "tcp://machine:2376")
+
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--hosts-file", hostsFile}, "tcp://a:2376,tcp://b:2376"},
+		{[]string{"--hosts-file", hostsFile, "--socket", "tcp://c:2376"}, "tcp://a:2376,tcp://b:2376,tcp://c:2376"},
+		{nil, "tcp://machine:2376"},
+	} {
+		sockets, err := dockerSockets(socketsContext(t, test.args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(sockets, ","); got != test.want {
+			t.Errorf("%v: got sockets %s, want %s", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hatchery/dgc/gc"
+)
+
+// metricInfo describes a metric in the Prometheus text exposition format.
+type metricInfo struct {
+	name string
+	kind string
+	help string
+}
+
+// metricInfos lists every metric in the order they are exposed.
+var metricInfos = []metricInfo{
+	{"dgc_runs_total", "counter", "Garbage collection passes, by whether every host succeeded."},
+	{"dgc_run_duration_seconds", "summary", "How long garbage collection passes took."},
+	{"dgc_last_run_timestamp_seconds", "gauge", "When the last garbage collection pass finished."},
+	{"dgc_resources_examined_total", "counter", "Resources examined, per host and resource type."},
+	{"dgc_resources_deleted_total", "counter", "Resources deleted, per host and resource type."},
+	{"dgc_resources_skipped_total", "counter", "Resources kept, per host, resource type and reason."},
+	{"dgc_resources_failed_total", "counter", "Resources that failed to delete, per host and resource type."},
+	{"dgc_reclaimed_bytes_total", "counter", "Bytes reclaimed by deleting resources, per host."},
+}
+
+// labelEscaper escapes label values for the text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats label pairs, given as name and value in turn, such as {host="..."}.
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// metrics accumulates what garbage collection passes did, to be exposed to Prometheus.
+// Dry runs delete nothing and are not recorded. A nil *metrics records nothing.
+type metrics struct {
+	lock sync.Mutex
+	// values holds the value of every series, by metric name and then formatted labels.
+	values map[string]map[string]float64
+}
+
+// newMetrics returns metrics with nothing recorded yet.
+func newMetrics() *metrics {
+	return &metrics{values: make(map[string]map[string]float64)}
+}
+
+// add adds delta to a series. The lock must be held.
+func (m *metrics) add(name, series string, delta float64) {
+	values, ok := m.values[name]
+	if !ok {
+		values = make(map[string]float64)
+		m.values[name] = values
+	}
+	values[series] += delta
+}
+
+// observe records a pass that ran from started to finished. err is set if the pass
+// couldn't start at all.
+func (m *metrics) observe(results []gc.Result, err error, started, finished time.Time) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	for _, result := range results {
+		if result.Failed() {
+			outcome = "failure"
+		}
+		var reclaimed int64
+		for _, k := range result.ByType() {
+			series := labels("host", result.Socket, "type", k.Kind)
+			m.add("dgc_resources_examined_total", series, float64(k.Examined))
+			m.add("dgc_resources_deleted_total", series, float64(k.Deleted))
+			m.add("dgc_resources_failed_total", series, float64(k.Failed))
+			for reason, count := range k.Skipped {
+				m.add("dgc_resources_skipped_total", labels("host", result.Socket, "type", k.Kind, "reason", reason), float64(count))
+			}
+			reclaimed += k.Reclaimed
+		}
+		m.add("dgc_reclaimed_bytes_total", labels("host", result.Socket), float64(reclaimed))
+	}
+
+	m.add("dgc_runs_total", labels("result", outcome), 1)
+	m.add("dgc_run_duration_seconds", "_sum", finished.Sub(started).Seconds())
+	m.add("dgc_run_duration_seconds", "_count", 1)
+	m.values["dgc_last_run_timestamp_seconds"] = map[string]float64{"": float64(finished.Unix())}
+}
+
+// write writes every metric in the Prometheus text exposition format.
+func (m *metrics) write(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var buf bytes.Buffer
+	for _, info := range metricInfos {
+		values := m.values[info.name]
+		if len(values) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", info.name, info.help, info.name, info.kind)
+		series := make([]string, 0, len(values))
+		for s := range values {
+			series = append(series, s)
+		}
+		sort.Strings(series)
+		for _, s := range series {
+			// Summaries are exposed as the _sum and _count series
+			fmt.Fprintf(&buf, "%s%s %s\n", info.name, s, strconv.FormatFloat(values[s], 'f', -1, 64))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeFile writes the metrics for the node exporter's textfile collector, replacing
+// the file atomically so that it is never scraped half written.
+func (m *metrics) writeFile(path string) error {
+	if m == nil || path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := m.write(&buf); err != nil {
+		return err
+	}
+	if err := gc.WriteFileAtomic(path, buf.Bytes()); err != nil {
+		return err
+	}
+	// The collector usually runs as another user
+	return os.Chmod(path, 0644)
+}
+
+// ServeHTTP exposes the metrics to Prometheus.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := m.write(w); err != nil {
+		log.Printf("Error. Failed to write metrics: %s\n", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	units "github.com/docker/go-units"
+	"github.com/hatchery/dgc/gc"
+)
+
+// The formats --output accepts. Text is meant for people, the others for tools.
+const (
+	outputText   = "text"
+	outputTable  = "table"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+	outputCSV    = "csv"
+)
+
+// outputFormats lists every format --output accepts.
+var outputFormats = []string{outputText, outputTable, outputJSON, outputNDJSON, outputCSV}
+
+// checkOutput returns an error if format isn't one --output accepts.
+func checkOutput(format string) error {
+	for _, f := range outputFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, use one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+// hostRecord is a record of the decision made about a resource on a host.
+type hostRecord struct {
+	Host string `json:"host"`
+	gc.Record
+}
+
+// typeSummary tallies the decisions made about one type of resource.
+type typeSummary struct {
+	Examined  int   `json:"examined"`
+	Deleted   int   `json:"deleted"`
+	Planned   int   `json:"planned"`
+	Kept      int   `json:"kept"`
+	Failed    int   `json:"failed"`
+	Reclaimed int64 `json:"reclaimed"`
+}
+
+// add adds what a pass did to a type of resource to the summary.
+func (s *typeSummary) add(result gc.TypeResult) {
+	s.Examined += result.Examined
+	s.Deleted += result.Deleted
+	s.Planned += len(result.Planned)
+	s.Kept += result.Kept()
+	s.Failed += result.Failed
+	s.Reclaimed += result.Reclaimed
+}
+
+// hostSummary is what a pass did to a single host.
+type hostSummary struct {
+	Host       string                 `json:"host"`
+	Error      string                 `json:"error,omitempty"`
+	DiskBefore *gc.DiskSnapshot       `json:"disk_before,omitempty"`
+	DiskAfter  *gc.DiskSnapshot       `json:"disk_after,omitempty"`
+	Types      map[string]typeSummary `json:"types"`
+}
+
+// runSummary is what a pass did across every docker host.
+type runSummary struct {
+	DryRun bool          `json:"dry_run"`
+	Hosts  []hostSummary `json:"hosts"`
+	Total  typeSummary   `json:"total"`
+}
+
+// resourceTypes lists the types of resources in the order they are collected.
+var resourceTypes = []string{"container", "image", "volume", "network"}
+
+// summarize tallies the results of a pass.
+func summarize(results []gc.Result, dryRun bool) runSummary {
+	summary := runSummary{DryRun: dryRun}
+	for _, result := range results {
+		host := hostSummary{
+			Host:       result.Socket,
+			DiskBefore: result.Before,
+			DiskAfter:  result.After,
+			Types:      make(map[string]typeSummary),
+		}
+		if result.Err != nil {
+			host.Error = result.Err.Error()
+		}
+		for _, t := range result.ByType() {
+			var s typeSummary
+			s.add(t)
+			host.Types[t.Kind] = s
+			summary.Total.add(t)
+		}
+		summary.Hosts = append(summary.Hosts, host)
+	}
+	return summary
+}
+
+// records returns the decision made about every resource examined during a pass.
+func records(results []gc.Result) []hostRecord {
+	all := []hostRecord{}
+	for _, result := range results {
+		for _, t := range result.ByType() {
+			for _, rec := range t.Records {
+				all = append(all, hostRecord{Host: result.Socket, Record: rec})
+			}
+		}
+	}
+	return all
+}
+
+// shortID shortens an ID the way the docker CLI does.
+func shortID(id string) string {
+	if i := strings.Index(id, ":"); i >= 0 {
+		id = id[i+1:]
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+// writeOutput writes a record per examined resource followed by a summary of the pass
+// in one of the machine readable formats.
+func writeOutput(w io.Writer, format string, results []gc.Result, dryRun bool) error {
+	all := records(results)
+	summary := summarize(results, dryRun)
+
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Records []hostRecord `json:"records"`
+			Summary runSummary   `json:"summary"`
+		}{all, summary})
+
+	case outputNDJSON:
+		// One record per line, then a line holding only the summary
+		encoder := json.NewEncoder(w)
+		for _, rec := range all {
+			if err := encoder.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return encoder.Encode(struct {
+			Summary runSummary `json:"summary"`
+		}{summary})
+
+	case outputCSV:
+		// The summary follows as one row per host and type with the decision "summary"
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"host", "type", "id", "names", "decision", "reason", "detail", "size", "error"})
+		for _, rec := range all {
+			writer.Write([]string{rec.Host, rec.Kind, rec.ID, strings.Join(rec.Names, ","), rec.Decision,
+				rec.Reason, rec.Detail, strconv.FormatInt(rec.Size, 10), rec.Error})
+		}
+		for _, host := range summary.Hosts {
+			for _, kind := range resourceTypes {
+				s := host.Types[kind]
+				detail := fmt.Sprintf("examined=%d deleted=%d planned=%d kept=%d failed=%d",
+					s.Examined, s.Deleted, s.Planned, s.Kept, s.Failed)
+				writer.Write([]string{host.Host, kind, "", "", "summary", "", detail,
+					strconv.FormatInt(s.Reclaimed, 10), host.Error})
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case outputTable:
+		table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(table, "HOST\tTYPE\tID\tNAMES\tDECISION\tREASON\tSIZE\tERROR")
+		for _, rec := range all {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.Host, rec.Kind, shortID(rec.ID),
+				strings.Join(rec.Names, ","), rec.Decision, rec.Reason, units.HumanSize(float64(rec.Size)), rec.Error)
+		}
+		fmt.Fprintln(table)
+		fmt.Fprintln(table, "HOST\tTYPE\tEXAMINED\tDELETED\tPLANNED\tKEPT\tFAILED\tRECLAIMED")
+		for _, host := range summary.Hosts {
+			for _, kind := range resourceTypes {
+				s := host.Types[kind]
+				fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n", host.Host, kind,
+					s.Examined, s.Deleted, s.Planned, s.Kept, s.Failed, units.HumanSize(float64(s.Reclaimed)))
+			}
+		}
+		return table.Flush()
+	}
+	return checkOutput(format)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	units "github.com/docker/go-units"
+	"github.com/hatchery/dgc/gc"
+)
+
+// printPlan prints the candidates that a dry run would have deleted, in the
+// order they would have been deleted.
+func printPlan(prefix string, candidates []gc.Candidate) {
+	var reclaimable int64
+	for _, c := range candidates {
+		names := strings.Join(c.Names, ",")
+		if names == "" {
+			names = "<none>"
+		}
+		fmt.Printf(prefix+"Would delete %s: %s %s (age %s, size %s): %s\n",
+			c.Kind, c.ID, names, units.HumanDuration(c.Age), units.HumanSize(float64(c.Size)), c.Rule)
+		reclaimable += c.Size
+	}
+	fmt.Printf(prefix+"Plan: %d resources would be deleted, up to %s\n", len(candidates), units.HumanSize(float64(reclaimable)))
+}
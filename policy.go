@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
+)
+
+// loadPolicy builds the collection policy from the global flags, or reads it from
+// the --config policy file, which replaces the flags that set the policy.
+func loadPolicy(ctx *cli.Context) (*gc.Policy, error) {
+	if ctx.String("config") != "" {
+		policy, err := gc.ReadPolicy(ctx.String("config"))
+		if err != nil {
+			return nil, fmt.Errorf("Error. Invalid policy file: %s", err)
+		}
+		// How the pass is run is still up to the flags
+		policy.DryRun = ctx.Bool("dry-run")
+		return policy, nil
+	}
+	policy := &gc.Policy{
+		Grace:         ctx.Duration("grace"),
+		States:        gc.ParseStates(ctx.String("states")),
+		Volumes:       ctx.Bool("volumes"),
+		VolumeLabels:  ctx.StringSlice("volume-label"),
+		Networks:      ctx.Bool("networks"),
+		RemoveVolumes: ctx.Bool("remove-volumes"),
+		Force:         ctx.Bool("force"),
+		DryRun:        ctx.Bool("dry-run"),
+	}
+	var err error
+	if ctx.String("exclude") != "" {
+		if policy.Excludes, err = gc.ReadExcludes(ctx.String("exclude")); err != nil {
+			return nil, err
+		}
+	}
+	for _, filter := range ctx.StringSlice("label-filter") {
+		selector, err := gc.ParseLabelSelector(filter)
+		if err != nil {
+			return nil, err
+		}
+		policy.Labels = append(policy.Labels, selector)
+	}
+	if policy.Retention, err = gc.ParseRetentionRules(ctx.Int("keep-recent"), ctx.StringSlice("keep-recent-for")); err != nil {
+		return nil, err
+	}
+	if policy.Watermarks, err = gc.ParseWatermarks(ctx.String("high-watermark"), ctx.String("low-watermark"), ctx.String("docker-root")); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
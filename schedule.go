@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// parseSchedule parses the --schedule flag. It accepts standard five field cron
+// expressions, descriptors such as "@hourly" or "@every 30m", and plain intervals
+// such as "30m".
+func parseSchedule(spec string) (cron.Schedule, error) {
+	if interval, err := time.ParseDuration(spec); err == nil {
+		return cron.Every(interval), nil
+	}
+	return cron.ParseStandard(spec)
+}
+
+// runScheduled calls pass on every activation of the schedule until the process
+// receives SIGINT or SIGTERM. An activation is skipped if the previous pass is
+// still running, and a pass in progress is allowed to finish before returning.
+func runScheduled(schedule cron.Schedule, pass func()) {
+	var passSync sync.WaitGroup
+	var runningLock sync.Mutex
+	running := false
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for {
+		next := schedule.Next(time.Now())
+		log.Printf("Next garbage collection at %s\n", next.Format(time.RFC3339))
+		timer := time.NewTimer(next.Sub(time.Now()))
+
+		select {
+		case sig := <-signals:
+			timer.Stop()
+			log.Printf("Received %s, waiting for the current pass to finish...\n", sig)
+			passSync.Wait()
+			log.Println("Stopped scheduled garbage collection.")
+			return
+		case <-timer.C:
+		}
+
+		runningLock.Lock()
+		if running {
+			runningLock.Unlock()
+			log.Println("Skipping garbage collection, the previous pass is still running.")
+			continue
+		}
+		running = true
+		runningLock.Unlock()
+
+		passSync.Add(1)
+		go func() {
+			defer passSync.Done()
+			defer func() {
+				runningLock.Lock()
+				running = false
+				runningLock.Unlock()
+			}()
+			pass()
+		}()
+	}
+}
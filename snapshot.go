@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
+)
+
+// runSnapshot writes everything a docker host reports about its containers, images,
+// volumes and networks as JSON, to a file or stdout, for dgc simulate to replay.
+func runSnapshot(ctx *cli.Context) error {
+	file := ctx.String("file")
+	// The docker host is chosen by the global flags
+	ctx = ctx.Parent()
+
+	sockets, err := dockerSockets(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(sockets) != 1 {
+		return cli.NewExitError(fmt.Sprintf("Error. A snapshot is taken of a single docker host, not %d", len(sockets)), 1)
+	}
+	client, err := newDockerClient(sockets[0], tlsOptions(ctx))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Failed to create a docker client to: %s: %s", sockets[0], err), 1)
+	}
+	defer client.Close()
+
+	snapshot, err := newCollector(sockets[0], client, nil).Snapshot()
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Failed to take a snapshot: %s", err), 1)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Failed to encode the snapshot: %s", err), 1)
+	}
+	data = append(data, '\n')
+	if file == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = gc.WriteFileAtomic(file, data)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Failed to write the snapshot: %s", err), 1)
+	}
+	return nil
+}
+
+// runSimulate plans a garbage collection pass against a snapshot instead of a docker
+// host, and reports what would be deleted and how much space that would free. The
+// policy is read from --config if given, otherwise from the global flags.
+func runSimulate(ctx *cli.Context) error {
+	snapshotFile, configFile := ctx.String("snapshot"), ctx.String("config")
+	ctx = ctx.Parent()
+
+	if snapshotFile == "" {
+		return cli.NewExitError("Error. --snapshot is required", 1)
+	}
+	format := ctx.String("output")
+	if err := checkOutput(format); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	snapshot, err := gc.ReadSnapshot(snapshotFile)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Failed to read the snapshot: %s", err), 1)
+	}
+	var policy *gc.Policy
+	if configFile != "" {
+		policy, err = gc.ReadPolicy(configFile)
+	} else {
+		policy, err = loadPolicy(ctx)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// The filesystem the snapshot was taken on isn't around to be measured
+	if policy.Watermarks != nil && policy.Watermarks.Percentage() {
+		return cli.NewExitError("Error. Watermarks can't be given as percentages when simulating, give them in bytes", 1)
+	}
+	policy.DryRun = true
+
+	collector := &gc.Collector{
+		Client: snapshot.Client(),
+		Socket: snapshot.Socket,
+		Policy: policy,
+		Log:    log.New(os.Stderr, "["+snapshot.Socket+"] ", log.LstdFlags),
+	}
+	result := collector.Collect()
+	if result.Err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Simulation failed: %s", result.Err), 1)
+	}
+	if format == outputText {
+		printPlan("", result.Planned())
+		return nil
+	}
+	if err := writeOutput(os.Stdout, format, []gc.Result{result}, true); err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error. Failed to write output: %s", err), 1)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	units "github.com/docker/go-units"
+	"github.com/hatchery/dgc/gc"
+)
+
+// printSummary prints what a pass did to a host, per type of resource, and how its disk
+// usage changed.
+func printSummary(prefix string, result gc.Result) {
+	var reclaimed int64
+	for _, k := range result.ByType() {
+		fmt.Printf(prefix+"Summary %ss: %d deleted, %d kept, %s reclaimed, %d failed\n",
+			k.Kind, k.Deleted, k.Kept(), units.HumanSize(float64(k.Reclaimed)), k.Failed)
+		reclaimed += k.Reclaimed
+	}
+	if result.Before != nil && result.After != nil {
+		fmt.Printf(prefix+"Summary disk usage: %s before, %s after, %s reclaimed\n",
+			units.HumanSize(float64(result.Before.Total())), units.HumanSize(float64(result.After.Total())),
+			units.HumanSize(float64(result.Before.Total()-result.After.Total())))
+	} else {
+		fmt.Printf(prefix+"Summary disk usage: %s reclaimed\n", units.HumanSize(float64(reclaimed)))
+	}
+}
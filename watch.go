@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/hatchery/dgc/gc"
+	"github.com/urfave/cli"
+)
+
+// runWatch collects containers as they exit, by following the events stream of every
+// docker host, until the process receives SIGINT or SIGTERM.
+func runWatch(ctx *cli.Context) error {
+	var watchSync sync.WaitGroup
+	// Collection is configured by the global flags
+	ctx = ctx.Parent()
+
+	sockets, err := dockerSockets(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	policy, err := loadPolicy(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := gc.NewWorkerPool(ctx.Int("concurrency"))
+	for _, socket := range sockets {
+		client, err := newDockerClient(socket, tlsOptions(ctx))
+		if err != nil {
+			// Stop the hosts already being watched, which shuts their watchers down
+			cancel()
+			watchSync.Wait()
+			return cli.NewExitError(fmt.Sprintf("Error. Failed to create a docker client to: %s: %s", socket, err), 1)
+		}
+		defer client.Close()
+		collector := newCollector(socket, client, policy)
+		collector.Workers = pool.ForHost(ctx.Int("host-concurrency"), ctx.Float64("rate"))
+		defer collector.Workers.Stop()
+		if policy.DryRun || !ctx.Bool("quiet") {
+			collector.OnRecord = printRecords(hostPrefix(socket, len(sockets)))
+		}
+		watchSync.Add(1)
+		go func() {
+			defer watchSync.Done()
+			collector.Watch(stop)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	sig := <-signals
+	log.Printf("Received %s, waiting for removals in progress to finish...\n", sig)
+	cancel()
+	watchSync.Wait()
+	log.Println("Stopped watching.")
+	return nil
+}